@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type sampleConfig struct {
+	// The period between samples.
+	// Sampling is disabled if not set.
+	Period string
+	// The aggregate statistics published - min, max, avg and pNN percentiles.
+	Stats []string
+}
+
+// aggregator collects samples of values between publishes, and summarises
+// them into aggregate statistics.
+//
+// A nil aggregator, i.e. with sampling disabled, is valid and summarises to
+// nothing.
+type aggregator struct {
+	stats   []string
+	mu      sync.Mutex
+	samples map[string][]float64
+}
+
+func newAggregator(cfg *sampleConfig) *aggregator {
+	if len(cfg.Period) == 0 {
+		return nil
+	}
+	for _, s := range cfg.Stats {
+		if _, ok := percentile(s); !ok && s != "min" && s != "max" && s != "avg" {
+			log.Fatalf("unsupported sample stat: %s", s)
+		}
+	}
+	return &aggregator{
+		stats:   slices.Clone(cfg.Stats),
+		samples: map[string][]float64{},
+	}
+}
+
+// percentile returns the percentile for a pNN stat.
+func percentile(stat string) (int, bool) {
+	if !strings.HasPrefix(stat, "p") {
+		return 0, false
+	}
+	p, err := strconv.Atoi(stat[1:])
+	if err != nil || p < 1 || p > 99 {
+		return 0, false
+	}
+	return p, true
+}
+
+// add adds a sample of the named value.
+func (a *aggregator) add(name string, v float64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.samples[name] = append(a.samples[name], v)
+	a.mu.Unlock()
+}
+
+// take returns the aggregate statistics of the samples collected since the
+// last take, keyed by <value>_<stat>, and starts a new window.
+//
+// The window is restarted even if the summary is not published, so each
+// summary covers a single polling period.
+func (a *aggregator) take() map[string]float64 {
+	sum := map[string]float64{}
+	if a == nil {
+		return sum
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name, samples := range a.samples {
+		if len(samples) == 0 {
+			continue
+		}
+		sorted := slices.Sorted(slices.Values(samples))
+		for _, s := range a.stats {
+			var v float64
+			switch s {
+			case "min":
+				v = sorted[0]
+			case "max":
+				v = sorted[len(sorted)-1]
+			case "avg":
+				for _, x := range sorted {
+					v += x
+				}
+				v /= float64(len(sorted))
+			default:
+				// nearest rank
+				p, _ := percentile(s)
+				rank := int(math.Ceil(float64(p) * float64(len(sorted)) / 100))
+				v = sorted[max(rank, 1)-1]
+			}
+			sum[name+"_"+s] = v
+		}
+	}
+	clear(a.samples)
+	return sum
+}
+
+// statNames returns the names of the aggregate statistics of the fields, as
+// keyed in the summary.
+func (a *aggregator) statNames(fields ...string) []string {
+	if a == nil {
+		return nil
+	}
+	names := []string{}
+	for _, f := range fields {
+		for _, s := range a.stats {
+			names = append(names, f+"_"+s)
+		}
+	}
+	return names
+}
+
+// summaryFields formats the summary as JSON fields, in a stable order.
+func summaryFields(sum map[string]float64) []string {
+	fields := []string{}
+	for _, k := range slices.Sorted(maps.Keys(sum)) {
+		fields = append(fields, fmt.Sprintf(`"%s": %.2f`, k, sum[k]))
+	}
+	return fields
+}
+
+// entityConfigs returns the config for the entities corresponding to the
+// aggregate statistics of the field, derived from the config of the entity
+// itself.
+func (a *aggregator) entityConfigs(ec EntityConfig, stopic, field string) []EntityConfig {
+	if a == nil {
+		return nil
+	}
+	var config []EntityConfig
+	for _, s := range a.stats {
+		cfg := maps.Clone(ec.config)
+		cfg["name"] = fmt.Sprintf("%s %s", ec.config["name"], s)
+		cfg["value_template"] = fmt.Sprintf("{{value_json.%s_%s | round(2)}}", field, s)
+		setFieldAvailability(cfg, stopic, field+"_"+s)
+		config = append(config, EntityConfig{ec.name + "_" + s, ec.class, cfg})
+	}
+	return config
+}
@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterModule("ap", newAP)
+}
+
+type apConfig struct {
+	pollerConfig `yaml:",inline"`
+	// The AP interfaces to report on.
+	// Defaults to all wireless interfaces operating as an AP.
+	Interfaces []string
+}
+
+// ap reports the stations associated with access point interfaces.
+type ap struct {
+	PolledSensor
+	// the interfaces are found, rather than configured, so may change
+	auto     bool
+	nl80211  nl80211Conn
+	stopic   string
+	log      *slog.Logger
+	unlisten func()
+
+	mu         sync.Mutex
+	interfaces []string
+	msgs       map[string]string
+}
+
+func newAP(mod *Module, yamlCfg *yaml.Node) SyncCloser {
+	cfg := apConfig{
+		pollerConfig: pollerConfig{Period: "1m"},
+	}
+	err := yamlCfg.Decode(&cfg)
+	if err != nil {
+		log.Fatalf("error reading ap config: %v", err)
+	}
+	a := ap{
+		auto:       len(cfg.Interfaces) == 0,
+		interfaces: cfg.Interfaces,
+		msgs:       map[string]string{},
+		stopic:     mod.StateTopic(""),
+		log:        mod.log,
+	}
+	if a.auto {
+		err := a.nl80211.do(func(c *nl80211) { a.interfaces = findAPs(c) })
+		if err != nil {
+			mod.log.Warn("unable to find AP interfaces", "err", err)
+		} else if len(a.interfaces) == 0 {
+			mod.log.Warn("no AP interfaces found")
+		}
+	}
+	a.poller = mod.NewPoller(&cfg.pollerConfig, a.Refresh)
+	if a.auto {
+		// rescan when an interface may have started or stopped being an AP
+		a.unlisten = linkEvents.listen(func(ifname string) {
+			if len(ifname) == 0 || isWireless(ifname) || slices.Contains(a.aps(), ifname) {
+				a.poller.RefreshAsync()
+			}
+		})
+	}
+	return &a
+}
+
+func (a *ap) Close() {
+	if a.unlisten != nil {
+		a.unlisten()
+	}
+	a.PolledSensor.Close()
+	a.nl80211.Close()
+}
+
+// aps returns the AP interfaces being reported on.
+func (a *ap) aps() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.interfaces)
+}
+
+// findAPs returns the wireless interfaces operating as an AP.
+func findAPs(c *nl80211) []string {
+	entries, err := os.ReadDir(hostPath("/sys/class/net"))
+	if err != nil {
+		return nil
+	}
+	aps := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if !isWireless(name) {
+			continue
+		}
+		iface, err := hostInterface(name)
+		if err != nil {
+			continue
+		}
+		if wi, err := c.getInterface(iface.Index); err == nil && wi.iftype == nl80211IftypeAP {
+			aps = append(aps, name)
+		}
+	}
+	return aps
+}
+
+func (a *ap) Config() []EntityConfig {
+	var config []EntityConfig
+	for _, name := range a.aps() {
+		topic := a.stopic + "/" + name
+		cfg := map[string]any{
+			"name":                     "AP " + name + " clients",
+			"state_topic":              topic,
+			"value_template":           "{{value_json.clients | is_defined}}",
+			"icon":                     "mdi:access-point-network",
+			"state_class":              "measurement",
+			"json_attributes_topic":    topic,
+			"json_attributes_template": "{{value_json.stations | tojson}}",
+		}
+		setFieldAvailability(cfg, topic, "clients")
+		config = append(config, EntityConfig{name + "-clients", "sensor", cfg})
+	}
+	return config
+}
+
+func (a *ap) Publish() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, name := range a.interfaces {
+		if msg, ok := a.msgs[name]; ok {
+			a.ps.Publish("/"+name, msg)
+		}
+	}
+}
+
+// Refresh reads the stations of each AP interface, first rescanning for AP
+// interfaces if they are not configured.
+func (a *ap) Refresh(forced bool) {
+	old := a.aps()
+	aps := old
+	msgs := map[string]string{}
+	err := a.nl80211.do(func(c *nl80211) {
+		if a.auto {
+			aps = findAPs(c)
+		}
+		for _, name := range aps {
+			msgs[name] = readAPStations(c, name)
+		}
+	})
+	if err != nil {
+		a.log.Warn("unable to read stations", "err", err)
+	}
+	changed := !slices.Equal(aps, old)
+	a.mu.Lock()
+	if changed {
+		a.log.Info("AP interfaces changed", "interfaces", aps)
+		a.interfaces = aps
+		for name := range a.msgs {
+			if !slices.Contains(aps, name) {
+				delete(a.msgs, name)
+			}
+		}
+	}
+	for _, name := range aps {
+		msg, ok := msgs[name]
+		if !ok {
+			msg = "{}"
+		}
+		if forced || msg != a.msgs[name] {
+			a.msgs[name] = msg
+			a.ps.Publish("/"+name, msg)
+		}
+	}
+	a.mu.Unlock()
+	if changed {
+		// the states of new interfaces are published before they are
+		// advertised, so publish them again once they are
+		rediscover(a.Publish)
+	}
+}
+
+// readAPStations returns the state message for an AP interface, being the
+// number of clients and the state of each client station, keyed by MAC.
+//
+// The clients are omitted if the stations cannot be read, so the entity
+// becomes unavailable.
+func readAPStations(c *nl80211, name string) string {
+	iface, err := hostInterface(name)
+	if err != nil {
+		return "{}"
+	}
+	ss, err := c.getStations(iface.Index)
+	if err != nil {
+		return "{}"
+	}
+	stations := []string{}
+	for _, s := range ss {
+		fields := []string{}
+		if s.hasSignal {
+			fields = append(fields, fmt.Sprintf(`"signal": %d`, s.signal))
+		}
+		if s.txBitrate > 0 {
+			fields = append(fields, fmt.Sprintf(`"tx_bitrate": %.1f`, s.txBitrate))
+		}
+		if s.rxBitrate > 0 {
+			fields = append(fields, fmt.Sprintf(`"rx_bitrate": %.1f`, s.rxBitrate))
+		}
+		fields = append(fields,
+			fmt.Sprintf(`"rx_bytes": %d`, s.rxBytes),
+			fmt.Sprintf(`"tx_bytes": %d`, s.txBytes),
+			fmt.Sprintf(`"connected": %d`, s.connected),
+		)
+		stations = append(stations, fmt.Sprintf(`"%s": {%s}`, s.mac, strings.Join(fields, ", ")))
+	}
+	return fmt.Sprintf(`{"clients": %d, "stations": {%s}}`, len(ss), strings.Join(stations, ", "))
+}
@@ -8,9 +8,12 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"log/slog"
+	"maps"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
@@ -29,8 +32,18 @@ type cpu struct {
 	temp        int64
 	haveTemp    bool
 	idlePercent float32
+	haveIdle    bool
 	uptime      float64
+	haveUptime  bool
 	msg         string
+	stopic      string
+	deadbands   *deadbands
+	thresholds  *thresholds
+	log         *slog.Logger
+	// sampling between publishes
+	sampler     *Poller
+	agg         *aggregator
+	sampleStats CPUStats
 }
 
 type cpuTemperatureConfig struct {
@@ -41,9 +54,12 @@ type cpuConfig struct {
 	pollerConfig `yaml:",inline"`
 	Entities     []string
 	Temperature  cpuTemperatureConfig
+	Deadband     map[string]deadbandConfig
+	Sample       sampleConfig
+	Thresholds   map[string]thresholdConfig
 }
 
-func newCPU(yamlCfg *yaml.Node) SyncCloser {
+func newCPU(mod *Module, yamlCfg *yaml.Node) SyncCloser {
 	cfg := cpuConfig{
 		pollerConfig: pollerConfig{Period: "1m"},
 		Entities:     []string{"temperature", "used_percent"},
@@ -59,53 +75,85 @@ func newCPU(yamlCfg *yaml.Node) SyncCloser {
 	}
 	stats, err := cpuStats()
 	if err != nil {
-		log.Fatalf("unable to read cpu stats: %v", err)
+		// not a config error, so leave it to the module to retry
+		panic(fmt.Sprintf("unable to read cpu stats: %v", err))
+	}
+	cpu := cpu{
+		entities:    entities,
+		stats:       stats,
+		stopic:      mod.StateTopic(""),
+		deadbands:   newDeadbands(cfg.Deadband),
+		log:         mod.log,
+		agg:         newAggregator(&cfg.Sample),
+		sampleStats: stats,
 	}
-	cpu := cpu{entities: entities, stats: stats}
 	if entities["temperature"] {
-		tpath := cfg.Temperature.Path
+		tpath := hostPath(cfg.Temperature.Path)
 		temp, err := cpuTemp(tpath)
 		if err == nil {
 			cpu.temp = temp
+			cpu.haveTemp = true
 		}
 		cpu.tpath = tpath
 	}
-	cpu.poller = NewPoller(&cfg.pollerConfig, cpu.Refresh)
+	tentities := append([]string{"temperature", "uptime", "used_percent"},
+		cpu.agg.statNames("temperature", "used_percent")...)
+	cpu.thresholds = newThresholds(cfg.Thresholds, tentities, "", cpu.topic, cpu.stopic)
+	cpu.poller = mod.NewPoller(&cfg.pollerConfig, cpu.Refresh)
+	if cpu.agg != nil {
+		cpu.sampler = mod.NewPoller(&pollerConfig{Period: cfg.Sample.Period}, cpu.sample)
+	}
 	return &cpu
 }
 
+// Close shuts down the polling and sampling.
+func (c *cpu) Close() {
+	c.PolledSensor.Close()
+	if c.sampler != nil {
+		c.sampler.Close()
+	}
+}
+
 func (c *cpu) Config() []EntityConfig {
 	var config []EntityConfig
 	if c.entities["used_percent"] {
 		cfg := map[string]any{
 			"name":                "CPU used percent",
-			"state_topic":         "~/cpu",
+			"state_topic":         c.stopic,
 			"value_template":      "{{(100 - value_json.idle_percent) | round(2)}}",
 			"unit_of_measurement": "%",
 			"icon":                "mdi:gauge",
 		}
-		config = append(config, EntityConfig{"used_percent", "sensor", cfg})
+		setFieldAvailability(cfg, c.stopic, "idle_percent")
+		ec := EntityConfig{"used_percent", "sensor", cfg}
+		config = append(config, ec)
+		config = append(config, c.agg.entityConfigs(ec, c.stopic, "used_percent")...)
 	}
 	if c.entities["temperature"] {
 		cfg := map[string]any{
 			"name":                "CPU temperature",
-			"state_topic":         "~/cpu",
+			"state_topic":         c.stopic,
 			"value_template":      "{{value_json.temperature | round(2) }}",
 			"device_class":        "temperature",
 			"unit_of_measurement": "°C",
 		}
-		config = append(config, EntityConfig{"temperature", "sensor", cfg})
+		setFieldAvailability(cfg, c.stopic, "temperature")
+		ec := EntityConfig{"temperature", "sensor", cfg}
+		config = append(config, ec)
+		config = append(config, c.agg.entityConfigs(ec, c.stopic, "temperature")...)
 	}
 	if c.entities["uptime"] {
 		cfg := map[string]any{
 			"name":                "Uptime",
-			"state_topic":         "~/cpu",
+			"state_topic":         c.stopic,
 			"value_template":      "{{value_json.uptime | int }}",
 			"device_class":        "duration",
 			"unit_of_measurement": "s",
 		}
+		setFieldAvailability(cfg, c.stopic, "uptime")
 		config = append(config, EntityConfig{"uptime", "sensor", cfg})
 	}
+	config = append(config, c.thresholds.Config()...)
 	return config
 }
 
@@ -115,7 +163,7 @@ type CPUStats [10]uint64
 
 func cpuStats() (CPUStats, error) {
 	var stats CPUStats
-	f, err := os.Open("/proc/stat")
+	f, err := os.Open(hostPath("/proc/stat"))
 	if err != nil {
 		return stats, err
 	}
@@ -123,11 +171,14 @@ func cpuStats() (CPUStats, error) {
 
 	scanner := bufio.NewScanner(f)
 	if !scanner.Scan() {
-		return stats, scanner.Err()
+		if err := scanner.Err(); err != nil {
+			return stats, err
+		}
+		return stats, errors.New("empty /proc/stat")
 	}
 	fields := strings.Fields(scanner.Text())
 	numFields := len(fields)
-	if fields[0] != "cpu" || numFields < 8 {
+	if numFields < 8 || fields[0] != "cpu" {
 		return stats, errors.Errorf("bad cpu line: %v", scanner.Text())
 	}
 	numStats := min(numFields-1, len(stats))
@@ -150,17 +201,21 @@ func cpuTemp(tpath string) (int64, error) {
 
 	scanner := bufio.NewScanner(f)
 	if !scanner.Scan() {
-		return 0, scanner.Err()
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.Errorf("empty %s", tpath)
 	}
 	return strconv.ParseInt(scanner.Text(), 10, 64)
 }
 
 func (c *cpu) Publish() {
 	c.ps.Publish(c.topic, c.msg)
+	c.thresholds.Publish(c.ps)
 }
 
 func uptime() (float64, error) {
-	f, err := os.Open("/proc/uptime")
+	f, err := os.Open(hostPath("/proc/uptime"))
 	if err != nil {
 		return 0, err
 	}
@@ -168,60 +223,101 @@ func uptime() (float64, error) {
 
 	scanner := bufio.NewScanner(f)
 	if !scanner.Scan() {
-		return 0, scanner.Err()
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, errors.New("empty /proc/uptime")
 	}
-	return strconv.ParseFloat(strings.Fields(scanner.Text())[0], 32)
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 2 {
+		return 0, errors.Errorf("bad uptime line: %v", scanner.Text())
+	}
+	return strconv.ParseFloat(fields[0], 32)
 }
 
 func (c *cpu) Refresh(forced bool) {
-	changed := forced
 	if c.entities["uptime"] {
-		if uptime, err := uptime(); err == nil {
-			c.uptime = uptime
-			changed = true
-		}
+		uptime, err := uptime()
+		c.uptime = uptime
+		c.haveUptime = err == nil
 	}
-	temp, err := cpuTemp(c.tpath)
-	if err == nil {
-		if temp != c.temp {
-			changed = true
-			c.temp = temp
-			c.haveTemp = true
-		}
+	if c.entities["temperature"] {
+		temp, err := cpuTemp(c.tpath)
+		c.temp = temp
+		c.haveTemp = err == nil
 	}
 	stats, err := cpuStats()
 	if err != nil {
-		log.Printf("unable to read cpu stats: %v", err)
+		c.log.Warn("unable to read cpu stats", "err", err)
+		c.haveIdle = false
+	} else {
+		if used, ok := usedPercent(c.stats, stats); ok {
+			c.idlePercent = float32(100 - used)
+			c.haveIdle = true
+		}
+		c.stats = stats
+	}
+	values := map[string]float64{}
+	if c.entities["used_percent"] && c.haveIdle {
+		values["used_percent"] = 100 - float64(c.idlePercent)
+	}
+	if c.haveTemp {
+		values["temperature"] = float64(c.temp) / 1000
+	}
+	if c.haveUptime {
+		values["uptime"] = c.uptime
+	}
+	sum := c.agg.take()
+	maps.Copy(values, sum)
+	now := time.Now()
+	c.thresholds.update(c.ps, values, now)
+	if !forced && !c.deadbands.exceeded(values, now) {
 		return
 	}
-	d := CPUStats{}
-	total := uint64(0)
-	for i := range len(d) {
-		d[i] = delta(c.stats[i], stats[i])
-		total += d[i]
-	}
-	if total != 0 {
-		idlePercent := float32((d[3]*10000)/total) / 100
-		if c.idlePercent != idlePercent {
-			changed = true
-			c.idlePercent = idlePercent
-		}
+	c.deadbands.published(values, now)
+	fields := []string{}
+	if c.entities["used_percent"] && c.haveIdle {
+		fields = append(fields, fmt.Sprintf(`"idle_percent": %.2f`, c.idlePercent))
 	}
-	if changed {
-		fields := []string{}
-		if c.entities["used_percent"] {
-			fields = append(fields, fmt.Sprintf(`"idle_percent": %.2f`, c.idlePercent))
-		}
-		if c.haveTemp {
-			fields = append(fields, fmt.Sprintf(`"temperature": %.2f`, float32(c.temp)/1000))
+	if c.haveTemp {
+		fields = append(fields, fmt.Sprintf(`"temperature": %.2f`, float32(c.temp)/1000))
+	}
+	if c.haveUptime {
+		fields = append(fields, fmt.Sprintf(`"uptime": %.2f`, c.uptime))
+	}
+	fields = append(fields, summaryFields(sum)...)
+	c.msg = "{" + strings.Join(fields, ", ") + "}"
+	c.Publish()
+}
+
+// sample collects a sample of the cpu usage and temperature for aggregation.
+func (c *cpu) sample(_ bool) {
+	if c.entities["used_percent"] {
+		stats, err := cpuStats()
+		if err == nil {
+			if used, ok := usedPercent(c.sampleStats, stats); ok {
+				c.agg.add("used_percent", used)
+			}
+			c.sampleStats = stats
 		}
-		if c.entities["uptime"] {
-			fields = append(fields, fmt.Sprintf(`"uptime": %.2f`, c.uptime))
+	}
+	if c.entities["temperature"] {
+		if temp, err := cpuTemp(c.tpath); err == nil {
+			c.agg.add("temperature", float64(temp)/1000)
 		}
-		c.msg = "{" + strings.Join(fields, ", ") + "}"
-		c.Publish()
 	}
-	c.stats = stats
+}
+
+// usedPercent returns the percentage of cpu time used between two readings.
+func usedPercent(old, new CPUStats) (float64, bool) {
+	total := uint64(0)
+	for i := range len(old) {
+		total += delta(old[i], new[i])
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return 100 - float64((delta(old[3], new[3])*10000)/total)/100, true
 }
 
 func delta(old, new uint64) uint64 {
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+type deadbandConfig struct {
+	// The minimum absolute change from the last published value required
+	// to publish.
+	Absolute float64
+	// The minimum change, as a percentage of the last published value,
+	// required to publish.
+	Percent float64
+	// The maximum interval between publishes, regardless of change.
+	Heartbeat string
+}
+
+type deadband struct {
+	absolute  float64
+	percent   float64
+	heartbeat time.Duration
+}
+
+// deadbands filters the publishing of the numeric values of a module state
+// message, so the message is only published when at least one value has
+// changed sufficiently, or a heartbeat is due.
+//
+// Values without a configured deadband are considered changed by any change.
+type deadbands struct {
+	db       map[string]deadband
+	last     map[string]float64
+	lastTime time.Time
+}
+
+func newDeadbands(cfg map[string]deadbandConfig) *deadbands {
+	db := map[string]deadband{}
+	for name, c := range cfg {
+		d := deadband{absolute: c.Absolute, percent: c.Percent}
+		if len(c.Heartbeat) > 0 {
+			hb, err := time.ParseDuration(c.Heartbeat)
+			if err != nil {
+				log.Fatalf("error parsing %s heartbeat '%s': %v", name, c.Heartbeat, err)
+			}
+			d.heartbeat = hb
+		}
+		db[name] = d
+	}
+	return &deadbands{db: db}
+}
+
+// exceeded returns true if the values should be published.
+//
+// That is if any value has moved outside its deadband or become available
+// or unavailable since the last publish, or a heartbeat is due.
+func (d *deadbands) exceeded(values map[string]float64, now time.Time) bool {
+	if len(values) != len(d.last) {
+		return true
+	}
+	for name, v := range values {
+		last, ok := d.last[name]
+		if !ok {
+			return true
+		}
+		db := d.db[name]
+		if db.heartbeat > 0 && now.Sub(d.lastTime) >= db.heartbeat {
+			return true
+		}
+		delta := math.Abs(v - last)
+		if delta == 0 {
+			continue
+		}
+		if db.absolute == 0 && db.percent == 0 {
+			return true
+		}
+		if db.absolute > 0 && delta >= db.absolute {
+			return true
+		}
+		if db.percent > 0 && delta >= math.Abs(last)*db.percent/100 {
+			return true
+		}
+	}
+	return false
+}
+
+// published records the values as having been published.
+func (d *deadbands) published(values map[string]float64, now time.Time) {
+	d.last = values
+	d.lastTime = now
+}
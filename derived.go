@@ -0,0 +1,304 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterModule("derived", newDerived)
+}
+
+// stateBus distributes the state published by modules to in-process
+// listeners, such as the derived module.
+type stateBus struct {
+	mu        sync.Mutex
+	id        int
+	listeners map[int]func(topic string, value any)
+}
+
+var states = stateBus{listeners: map[int]func(string, any){}}
+
+// listen adds a listener for module states, returning a function to remove it.
+//
+// Topics are relative to the base topic, e.g. net/eth0/stats.
+// Listeners are called from the publishing module's goroutine, so must not
+// block.
+func (b *stateBus) listen(f func(topic string, value any)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.id++
+	id := b.id
+	b.listeners[id] = f
+	return func() {
+		b.mu.Lock()
+		delete(b.listeners, id)
+		b.mu.Unlock()
+	}
+}
+
+func (b *stateBus) publish(topic string, value any) {
+	b.mu.Lock()
+	ll := slices.Collect(maps.Values(b.listeners))
+	b.mu.Unlock()
+	for _, f := range ll {
+		f(topic, value)
+	}
+}
+
+type derivedEntityConfig struct {
+	// The name of the entity in HA.
+	// Defaults to the entity key with underscores replaced by spaces.
+	Name string
+	// The values used by the expression, keyed by variable name.
+	// Each is a module state topic, relative to the base topic, and an
+	// optional JSON field, e.g. net/eth0/stats:rx_throughput.
+	Inputs map[string]string
+	// The expression computing the entity value from the inputs.
+	Expression string
+	// Passed through to the entity config.
+	UnitOfMeasurement string `yaml:"unit_of_measurement"`
+	DeviceClass       string `yaml:"device_class"`
+	StateClass        string `yaml:"state_class"`
+	Icon              string
+}
+
+type derivedConfig struct {
+	Entities map[string]derivedEntityConfig
+}
+
+type derivedInput struct {
+	topic string
+	// path of the field within the JSON state, or nil for the whole state.
+	field []string
+}
+
+type derivedEntity struct {
+	name string
+	cfg  derivedEntityConfig
+	expr expr
+	// the input for each variable in the expression
+	inputs map[string]derivedInput
+}
+
+type derived struct {
+	name     string
+	entities []derivedEntity
+	stopic   string
+	log      *slog.Logger
+	cancel   func()
+
+	mu sync.Mutex
+	ps PubSub
+	// latest input values, keyed by entity and variable
+	values map[string]float64
+	msg    string
+}
+
+func newDerived(mod *Module, yamlCfg *yaml.Node) SyncCloser {
+	cfg := derivedConfig{}
+	err := yamlCfg.Decode(&cfg)
+	if err != nil {
+		log.Fatalf("error reading derived config: %v", err)
+	}
+	d := derived{
+		name:   mod.name,
+		stopic: mod.StateTopic(""),
+		log:    mod.log,
+		ps:     StubPubSub{},
+		values: map[string]float64{},
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Entities)) {
+		ec := cfg.Entities[name]
+		e, vars, err := compileExpr(ec.Expression)
+		if err != nil {
+			log.Fatalf("error parsing derived %s expression '%s': %v", name, ec.Expression, err)
+		}
+		inputs := map[string]derivedInput{}
+		for _, v := range vars {
+			in, ok := ec.Inputs[v]
+			if !ok {
+				log.Fatalf("derived %s: no input for %s", name, v)
+			}
+			inputs[v] = parseDerivedInput(in)
+			if t := inputs[v].topic; t == mod.name || strings.HasPrefix(t, mod.name+"/") {
+				log.Fatalf("derived %s: input %s references the derived module", name, v)
+			}
+		}
+		d.entities = append(d.entities, derivedEntity{name, ec, e, inputs})
+	}
+	d.cancel = states.listen(func(topic string, value any) {
+		mod.guard(func() { d.update(topic, value) })
+	})
+	return &d
+}
+
+// parseDerivedInput splits an input into its topic and field path.
+func parseDerivedInput(s string) derivedInput {
+	topic, field, ok := strings.Cut(s, ":")
+	in := derivedInput{topic: strings.Trim(topic, "/")}
+	if ok {
+		in.field = strings.Split(field, ".")
+	}
+	return in
+}
+
+// derivedValue extracts a numeric value from a module state.
+//
+// Binary states, such as online/offline, are treated as 1/0.
+func derivedValue(value any, field []string) (float64, bool) {
+	if len(field) > 0 {
+		var state any
+		if err := json.Unmarshal([]byte(fmt.Sprint(value)), &state); err != nil {
+			return 0, false
+		}
+		for _, f := range field {
+			m, ok := state.(map[string]any)
+			if !ok {
+				return 0, false
+			}
+			state = m[f]
+		}
+		value = state
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case nil:
+		return 0, false
+	}
+	s := fmt.Sprint(value)
+	switch s {
+	case "on", "online", "up":
+		return 1, true
+	case "off", "offline", "down":
+		return 0, true
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
+
+// update records any inputs provided by the state, and republishes the
+// entities if their values have changed.
+func (d *derived) update(topic string, value any) {
+	if topic == d.name || strings.HasPrefix(topic, d.name+"/") {
+		return
+	}
+	d.mu.Lock()
+	for _, e := range d.entities {
+		for v, in := range e.inputs {
+			if in.topic != topic {
+				continue
+			}
+			key := e.name + ":" + v
+			if x, ok := derivedValue(value, in.field); ok {
+				d.values[key] = x
+			} else {
+				delete(d.values, key)
+			}
+		}
+	}
+	changed := d.refresh()
+	ps, msg := d.ps, d.msg
+	d.mu.Unlock()
+	if changed {
+		ps.Publish("", msg)
+	}
+}
+
+// refresh recomputes the entity values, returning true if the state has
+// changed.
+//
+// Entities with unavailable inputs are omitted from the state.
+func (d *derived) refresh() bool {
+	fields := []string{}
+	for _, e := range d.entities {
+		vars := map[string]float64{}
+		for v := range e.inputs {
+			if x, ok := d.values[e.name+":"+v]; ok {
+				vars[v] = x
+			}
+		}
+		x, err := e.expr(vars)
+		if err != nil {
+			d.log.Debug("derived value unavailable", "entity", e.name, "err", err)
+			continue
+		}
+		fields = append(fields, fmt.Sprintf(`"%s": %s`, e.name, strconv.FormatFloat(x, 'f', -1, 64)))
+	}
+	msg := "{" + strings.Join(fields, ", ") + "}"
+	if msg == d.msg {
+		return false
+	}
+	d.msg = msg
+	return true
+}
+
+func (d *derived) Config() []EntityConfig {
+	var config []EntityConfig
+	for _, e := range d.entities {
+		name := e.cfg.Name
+		if len(name) == 0 {
+			name = strings.ReplaceAll(e.name, "_", " ")
+		}
+		cfg := map[string]any{
+			"name":           name,
+			"state_topic":    d.stopic,
+			"value_template": fmt.Sprintf("{{value_json.%s | is_defined}}", e.name),
+		}
+		if len(e.cfg.UnitOfMeasurement) > 0 {
+			cfg["unit_of_measurement"] = e.cfg.UnitOfMeasurement
+		}
+		if len(e.cfg.DeviceClass) > 0 {
+			cfg["device_class"] = e.cfg.DeviceClass
+		}
+		if len(e.cfg.StateClass) > 0 {
+			cfg["state_class"] = e.cfg.StateClass
+		}
+		if len(e.cfg.Icon) > 0 {
+			cfg["icon"] = e.cfg.Icon
+		}
+		setFieldAvailability(cfg, d.stopic, e.name)
+		config = append(config, EntityConfig{e.name, "sensor", cfg})
+	}
+	return config
+}
+
+func (d *derived) Publish() {
+	d.mu.Lock()
+	ps, msg := d.ps, d.msg
+	d.mu.Unlock()
+	if len(msg) > 0 {
+		ps.Publish("", msg)
+	}
+}
+
+func (d *derived) Sync(ps PubSub) {
+	d.mu.Lock()
+	d.ps = ps
+	d.refresh()
+	d.mu.Unlock()
+	d.Publish()
+}
+
+func (d *derived) Close() {
+	d.cancel()
+}
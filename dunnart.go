@@ -11,9 +11,14 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"maps"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -27,6 +32,8 @@ const (
 
 var (
 	version = "undefined"
+	// the root of the host filesystem being monitored, if not /.
+	hostRoot string
 )
 
 type discoveryConfig struct {
@@ -53,8 +60,12 @@ type mqttConfig struct {
 type config struct {
 	HomeAssistant homeAssistantConfig
 	Mqtt          mqttConfig
+	Log           logConfig
+	HostRoot      string `yaml:"host_root"`
+	State         stateConfig
 	Modules       []string
 	mm            map[string]yaml.Node
+	verbose       bool
 }
 
 func loadConfig() config {
@@ -76,9 +87,11 @@ func loadConfig() config {
 	}
 	configFile, ok := os.LookupEnv("DUNNART_CONFIG_FILE")
 	if !ok {
-		flag.StringVar(&configFile, "c", "dunnart.yaml", "configuration file")
-		flag.Parse()
+		configFile = "dunnart.yaml"
 	}
+	flag.StringVar(&configFile, "c", configFile, "configuration file")
+	flag.BoolVar(&cfg.verbose, "v", false, "enable debug logging")
+	flag.Parse()
 	ycfg, err := os.ReadFile(configFile)
 	if err != nil {
 		log.Fatalf("error reading config file: %v", err)
@@ -125,6 +138,8 @@ func (d *dunnart) Publish() {
 func (d *dunnart) Sync(ps PubSub) {
 	d.ps = ps
 	d.Publish()
+	ps.Publish("/log_level", logLevel.Level())
+	ps.Subscribe("/rqd/log_level", func(b []byte) { setLogLevel(ps, b) })
 }
 
 func (d *dunnart) Config() []EntityConfig {
@@ -151,18 +166,24 @@ func connect(mc mqtt.Client, done <-chan struct{}) error {
 	}
 }
 
+// initialConnect connects to the broker, retrying until successful.
+//
+// Each failed attempt extends the systemd start timeout, so the service is
+// only restarted if an attempt hangs.
 func initialConnect(mc mqtt.Client, done <-chan struct{}) {
 	err := connect(mc, done)
 	if err == nil {
 		return
 	}
-	log.Printf("connect error: %s", err)
+	slog.Warn("connect error", "err", err)
+	sdNotify("STATUS=connect error: " + err.Error() + "\nEXTEND_TIMEOUT_USEC=90000000")
 	t := time.NewTicker(5 * time.Second)
 	defer t.Stop()
 	for range t.C {
 		err = connect(mc, done)
 		if err != nil {
-			log.Printf("connect error: %s", err)
+			slog.Warn("connect error", "err", err)
+			sdNotify("STATUS=connect error: " + err.Error() + "\nEXTEND_TIMEOUT_USEC=90000000")
 		} else {
 			return
 		}
@@ -170,7 +191,9 @@ func initialConnect(mc mqtt.Client, done <-chan struct{}) {
 }
 
 // ModuleFactory creates a module with the given config.
-type ModuleFactory func(cfg *yaml.Node) SyncCloser
+// The module should create its pollers via the Module so that any panics
+// are isolated to the module.
+type ModuleFactory func(mod *Module, cfg *yaml.Node) SyncCloser
 
 var moduleFactories = map[string]ModuleFactory{}
 
@@ -180,10 +203,28 @@ func RegisterModule(name string, mf ModuleFactory) {
 	moduleFactories[name] = mf
 }
 
+// moduleType returns the type of module for a module instance.
+//
+// Multiple instances of a module type may be named <type>@<instance>, or
+// have any name with the type set by the module field in the instance config.
+func moduleType(name string, cfg *yaml.Node) string {
+	var mcfg struct {
+		Module string
+	}
+	if err := cfg.Decode(&mcfg); err == nil && len(mcfg.Module) > 0 {
+		return mcfg.Module
+	}
+	mtype, _, _ := strings.Cut(name, "@")
+	return mtype
+}
+
 func main() {
 	log.SetFlags(0)
 
 	cfg := loadConfig()
+	setupLogging(&cfg.Log, cfg.verbose)
+	hostRoot = cfg.HostRoot
+	setupState(&cfg.State)
 
 	// capture exit signals to ensure defers are called on the way out.
 	sigdone := make(chan os.Signal, 1)
@@ -203,11 +244,18 @@ func main() {
 	}
 
 	for modName, modCfg := range cfg.mm {
-		factory := moduleFactories[modName]
+		factory := moduleFactories[moduleType(modName, &modCfg)]
 		if factory == nil {
 			log.Fatalf("unsupported sensor: %s", modName)
 		}
-		mod := factory(&modCfg)
+		mod := newModule(modName, factory, &modCfg)
+		if level, ok := cfg.Log.Modules[modName]; ok {
+			l, err := parseLevel(level)
+			if err != nil {
+				log.Fatalf("error parsing log level '%s' for %s: %v", level, modName, err)
+			}
+			mod.level.Set(l)
+		}
 		ss[modName] = mod
 		defer mod.Close()
 	}
@@ -216,13 +264,23 @@ func main() {
 	mOpts := newMQTTOpts(&cfg.Mqtt).
 		SetWill(cfg.Mqtt.BaseTopic, "offline", mustQos, false).
 		SetOnConnectHandler(func(mc mqtt.Client) {
+			connectCount.Add(1)
+			lastConnect.Store(time.Now().Unix())
 			select {
 			case connect <- 0:
 			case <-done:
 			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("mqtt connection lost", "err", err)
+			sdNotify("STATUS=connection lost: " + err.Error())
+		}).
+		SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+			sdNotify("STATUS=reconnecting to " + cfg.Mqtt.Broker)
 		})
 
 	mc := mqtt.NewClient(mOpts)
+	sdNotify("STATUS=connecting to " + cfg.Mqtt.Broker)
 	initialConnect(mc, done)
 	defer mc.Disconnect(0)
 
@@ -233,29 +291,64 @@ func main() {
 		log.Fatalf("error parsing status_delay '%s': %v", cfg.HomeAssistant.Discovery.StatusDelay, err)
 	}
 	go func() {
+		ready := false
 		for {
 			select {
 			case <-done:
 				return
+			case <-discoveryChanged:
+				if mc.IsConnected() {
+					disco.advertise(mc)
+					rediscoveredMu.Lock()
+					ff := rediscovered
+					rediscovered = nil
+					rediscoveredMu.Unlock()
+					go safeCall(slog.Default(), func() {
+						time.Sleep(sdelay)
+						for _, f := range ff {
+							f()
+						}
+					})
+				}
 			case <-connect:
-				log.Print("mqtt connect")
+				slog.Info("mqtt connect", "broker", cfg.Mqtt.Broker)
 				disco.advertise(mc)
+				// everything is published below, so pending functions are
+				// redundant
+				rediscoveredMu.Lock()
+				rediscovered = nil
+				rediscoveredMu.Unlock()
 				for modName, s := range ss {
 					t := cfg.Mqtt.BaseTopic
 					if len(modName) > 0 {
 						t += "/" + modName
 					}
-					ps := mqttPubSub{mc, t}
+					ps := mqttPubSub{mc, t, slog.Default()}
+					if mod, ok := s.(*Module); ok {
+						ps.log = mod.log
+					}
 					s.Sync(ps)
 				}
+				status := "STATUS=connected to " + cfg.Mqtt.Broker
+				if !ready {
+					// ready once connected and advertised
+					status = "READY=1\n" + status
+					ready = true
+					// the watchdog only covers the pollers, so is not
+					// started until after the initial connect
+					go sdWatchdog(done)
+				}
+				sdNotify(status)
 				mc.Subscribe(cfg.HomeAssistant.BirthMessageTopic, mustQos,
 					func(mc mqtt.Client, msg mqtt.Message) {
 						if string(msg.Payload()) == "online" {
-							disco.advertise(mc)
-							time.Sleep(sdelay)
-							for _, s := range ss {
-								s.Publish()
-							}
+							safeCall(slog.Default(), func() {
+								disco.advertise(mc)
+								time.Sleep(sdelay)
+								for _, s := range ss {
+									s.Publish()
+								}
+							})
 						}
 					})
 				time.Sleep(sdelay)
@@ -267,32 +360,77 @@ func main() {
 		}
 	}()
 	<-done
+	sdNotify("STOPPING=1")
+}
+
+// discoveryChanged signals that the set of discoverable entities has
+// changed, such as a network interface appearing, so they must be
+// re-advertised.
+var discoveryChanged = make(chan struct{}, 1)
+
+var (
+	rediscoveredMu sync.Mutex
+	// functions to call once the entities have been re-advertised
+	rediscovered []func()
+)
+
+// rediscover requests the discoverable entities be re-advertised.
+//
+// The functions are called once the entities have been advertised, and HA
+// has had time to subscribe to them, such as to publish the state of new
+// entities.
+func rediscover(ff ...func()) {
+	rediscoveredMu.Lock()
+	rediscovered = append(rediscovered, ff...)
+	rediscoveredMu.Unlock()
+	select {
+	case discoveryChanged <- struct{}{}:
+	default:
+	}
 }
 
 type discovery struct {
-	// map from topic to config for discoverable entities
-	ents map[string]string
+	cfg       *discoveryConfig
+	ss        map[string]Syncer
+	baseTopic string
+	mac       string
+	uid       string
+
+	mu sync.Mutex
+	// the topics of the entities last advertised
+	advertised map[string]bool
 }
 
-func newDiscovery(cfg *discoveryConfig, ss map[string]Syncer, baseTopic string) discovery {
-	ents := map[string]string{}
+func newDiscovery(cfg *discoveryConfig, ss map[string]Syncer, baseTopic string) *discovery {
+	d := discovery{cfg: cfg, ss: ss, baseTopic: baseTopic}
 	if len(cfg.Prefix) > 0 {
 		mac, err := getMAC(cfg)
 		if err != nil {
 			log.Fatalf("discovery: %v", err)
 		}
-		uid := cfg.UniqueID
-		if len(uid) == 0 {
-			uid = "dnrt-" + strings.ReplaceAll(mac, ":", "")
+		d.mac = mac
+		d.uid = cfg.UniqueID
+		if len(d.uid) == 0 {
+			d.uid = "dnrt-" + strings.ReplaceAll(mac, ":", "")
 		}
+	}
+	return &d
+}
+
+// entities returns the map from topic to config for the discoverable
+// entities.
+func (d *discovery) entities() map[string]string {
+	ents := map[string]string{}
+	cfg, uid := d.cfg, d.uid
+	if len(cfg.Prefix) > 0 {
 		baseCfg := map[string]any{
-			"~": baseTopic,
+			"~": d.baseTopic,
 			"device": map[string]any{
 				"name":        cfg.NodeID,
-				"connections": [][]string{{"mac", mac}},
+				"connections": [][]string{{"mac", d.mac}},
 			},
 		}
-		for modName, s := range ss {
+		for modName, s := range d.ss {
 			if a, ok := s.(discoverable); ok {
 				for _, entity := range a.Config() {
 					euid := uid
@@ -303,25 +441,47 @@ func newDiscovery(cfg *discoveryConfig, ss map[string]Syncer, baseTopic string)
 					topic := strings.Join(
 						[]string{cfg.Prefix,
 							entity.class,
-							euid,
+							discoveryIDReplacer.Replace(euid),
 							"config"},
 						"/")
+					// the config is modified below, so work on a copy in case
+					// the module returns the same config on each call.
+					ecfg := maps.Clone(entity.config)
+					if len(modName) > 0 {
+						addAvailability(ecfg, moduleAvailability(modName))
+					}
 					baseCfg["unique_id"] = euid
 					baseCfg["object_id"] = strings.Join([]string{cfg.NodeID, modName, entity.name}, "_")
-					config := normaliseConfig(entity.config, baseCfg)
+					config := normaliseConfig(ecfg, baseCfg)
 					config = strings.ReplaceAll(config, "{{.NodeID}}", cfg.NodeID)
 					ents[topic] = config
 				}
 			}
 		}
 	}
-	return discovery{ents: ents}
+	return ents
 }
 
+// discoveryIDReplacer maps characters not permitted in HA discovery topic
+// IDs, such as the @ in module instance names.
+var discoveryIDReplacer = strings.NewReplacer("@", "_", ".", "_", " ", "_")
+
+// advertise publishes the config of the discoverable entities, and removes
+// any entities advertised previously that no longer exist.
 func (d *discovery) advertise(mc mqtt.Client) {
-	log.Print("advertise for ha discovery")
-	for topic, config := range d.ents {
+	slog.Info("advertise for ha discovery")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ents := d.entities()
+	for topic := range d.advertised {
+		if _, ok := ents[topic]; !ok {
+			mc.Publish(topic, mustQos, false, "")
+		}
+	}
+	d.advertised = map[string]bool{}
+	for topic, config := range ents {
 		mc.Publish(topic, mustQos, false, config)
+		d.advertised[topic] = true
 	}
 }
 
@@ -330,7 +490,7 @@ func getMAC(cfg *discoveryConfig) (string, error) {
 		return cfg.Mac, nil
 	}
 	for _, source := range cfg.MacSource {
-		v, err := os.ReadFile(fmt.Sprintf("/sys/class/net/%s/address", source))
+		v, err := os.ReadFile(hostPath(fmt.Sprintf("/sys/class/net/%s/address", source)))
 		if err == nil {
 			return strings.TrimSpace(string(v)), nil
 		}
@@ -338,6 +498,15 @@ func getMAC(cfg *discoveryConfig) (string, error) {
 	return "", errors.New("can't find a MAC address - check your homeassistant.discovery.mac_source configuration")
 }
 
+// hostPath returns the path to a file on the monitored host, which may be
+// mounted under host_root when running in a container.
+func hostPath(path string) string {
+	if len(hostRoot) == 0 {
+		return path
+	}
+	return filepath.Join(hostRoot, path)
+}
+
 func normaliseConfig(cfg, baseCfg map[string]any) string {
 	for k, v := range baseCfg {
 		if _, exists := cfg[k]; !exists {
@@ -365,6 +534,36 @@ func configContains(cfg map[string]any, key string) bool {
 	return ok
 }
 
+// addAvailability makes the entity additionally dependent on the
+// availability published to the topic.
+func addAvailability(cfg map[string]any, topic string) {
+	if configContains(cfg, "availability_topic") {
+		return
+	}
+	avail, ok := cfg["availability"].([]map[string]string)
+	if !ok {
+		avail = []map[string]string{{"topic": "~"}}
+	}
+	// copy rather than append to avoid modifying a shared slice
+	cfg["availability"] = append(slices.Clone(avail), map[string]string{"topic": topic})
+	cfg["availability_mode"] = "all"
+}
+
+// setFieldAvailability makes the entity available only while both dunnart is
+// online and the field is present in the JSON state published to the topic.
+//
+// Modules omit a field from their state when the underlying read fails, so
+// this marks the entity unavailable in HA rather than leaving a stale value.
+func setFieldAvailability(cfg map[string]any, topic, field string) {
+	cfg["availability"] = []map[string]string{
+		{"topic": "~"},
+		{"topic": topic,
+			"value_template": fmt.Sprintf("{{'online' if value_json.%s is defined else 'offline'}}", field),
+		},
+	}
+	cfg["availability_mode"] = "all"
+}
+
 // Syncer is a type that syncs its state with MQTT.
 type Syncer interface {
 	// Check the current state of contained entities and publish any state changes.
@@ -406,12 +605,23 @@ type PubSub interface {
 type mqttPubSub struct {
 	mc        mqtt.Client
 	baseTopic string
+	log       *slog.Logger
 }
 
 // Publish publishes a topic to the MQTT broker.
 func (m mqttPubSub) Publish(topic string, value any) {
-	log.Printf("publish %s '%s'", m.baseTopic+topic, fmt.Sprint(value))
-	m.mc.Publish(m.baseTopic+topic, mustQos, false, fmt.Sprint(value))
+	m.log.Debug("publish", "topic", m.baseTopic+topic, "value", fmt.Sprint(value))
+	tok := m.mc.Publish(m.baseTopic+topic, mustQos, false, fmt.Sprint(value))
+	publishCount.Add(1)
+	// only count failures that are immediately apparent, e.g. not connected,
+	// rather than blocking waiting for the publish to complete.
+	select {
+	case <-tok.Done():
+		if tok.Error() != nil {
+			publishFailures.Add(1)
+		}
+	default:
+	}
 }
 
 // Subscribe subscribes to a topic on the MQTT broker.
@@ -419,7 +629,7 @@ func (m mqttPubSub) Subscribe(topic string, callback func([]byte)) {
 	wrap := func(m mqtt.Client, msg mqtt.Message) {
 		callback(msg.Payload())
 	}
-	log.Printf("subscribe %s", m.baseTopic+topic)
+	m.log.Debug("subscribe", "topic", m.baseTopic+topic)
 	m.mc.Subscribe(m.baseTopic+topic, mustQos, wrap)
 }
 
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+// cachedSensor returns the same config on each call, as a module may.
+type cachedSensor struct {
+	cfg []EntityConfig
+}
+
+func (s *cachedSensor) Sync(PubSub)            {}
+func (s *cachedSensor) Publish()               {}
+func (s *cachedSensor) Config() []EntityConfig { return s.cfg }
+
+func TestDiscoveryEntitiesStable(t *testing.T) {
+	s := &cachedSensor{cfg: []EntityConfig{
+		{"plain", "sensor", map[string]any{"state_topic": "~/mod/plain"}},
+		{"avail", "sensor", map[string]any{
+			"state_topic":  "~/mod/avail",
+			"availability": []map[string]string{{"topic": "~"}, {"topic": "~/mod/avail"}},
+		}},
+	}}
+	d := discovery{
+		cfg:       &discoveryConfig{Prefix: "homeassistant", NodeID: "node"},
+		ss:        map[string]Syncer{"mod": s},
+		baseTopic: "dunnart/node",
+		uid:       "uid",
+	}
+	first := d.entities()
+	if len(first) != 2 {
+		t.Fatalf("got %d entities, want 2", len(first))
+	}
+	for range 3 {
+		if ents := d.entities(); !maps.Equal(ents, first) {
+			t.Fatalf("entities changed: got %v, want %v", ents, first)
+		}
+	}
+	if len(s.cfg[0].config) != 1 {
+		t.Errorf("plain config modified: %v", s.cfg[0].config)
+	}
+	if avail := s.cfg[1].config["availability"].([]map[string]string); len(avail) != 2 {
+		t.Errorf("availability modified: %v", avail)
+	}
+}
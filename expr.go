@@ -0,0 +1,283 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// expr is a compiled arithmetic expression over named variables.
+type expr func(vars map[string]float64) (float64, error)
+
+// exprFuncs are the functions available in expressions.
+var exprFuncs = map[string]func(args []float64) (float64, error){
+	"abs": func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, errors.New("abs takes one argument")
+		}
+		return math.Abs(args[0]), nil
+	},
+	"min": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, errors.New("min requires arguments")
+		}
+		return slices.Min(args), nil
+	},
+	"max": func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, errors.New("max requires arguments")
+		}
+		return slices.Max(args), nil
+	},
+	"round": func(args []float64) (float64, error) {
+		switch len(args) {
+		case 1:
+			return math.Round(args[0]), nil
+		case 2:
+			scale := math.Pow(10, args[1])
+			return math.Round(args[0]*scale) / scale, nil
+		}
+		return 0, errors.New("round takes one or two arguments")
+	},
+}
+
+// compileExpr compiles an expression supporting numbers, variables,
+// + - * / %, unary minus, parentheses and the exprFuncs.
+//
+// Returns the compiled expression and the names of the variables it uses.
+//
+// Evaluations with non-finite results, such as from overflow, return an
+// error, so the result is unavailable.
+func compileExpr(s string) (expr, []string, error) {
+	p := exprParser{s: s}
+	p.next()
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.tok != "" {
+		return nil, nil, errors.Errorf("unexpected '%s' at %d", p.tok, p.pos)
+	}
+	return func(vars map[string]float64) (float64, error) {
+		v, err := e(vars)
+		if err != nil {
+			return 0, err
+		}
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return 0, errors.New("result is not finite")
+		}
+		return v, nil
+	}, p.vars, nil
+}
+
+type exprParser struct {
+	s    string
+	pos  int
+	tok  string
+	vars []string
+}
+
+// next advances to the next token - a number, identifier, or operator.
+func (p *exprParser) next() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if p.pos >= len(p.s) {
+		p.tok = ""
+		return
+	}
+	start := p.pos
+	c := rune(p.s[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		p.skipDigits()
+		// with an optionally signed exponent
+		if p.pos < len(p.s) && (p.s[p.pos] == 'e' || p.s[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.s) && (p.s[p.pos] == '+' || p.s[p.pos] == '-') {
+				p.pos++
+			}
+			p.skipDigits()
+		}
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos < len(p.s) && (unicode.IsLetter(rune(p.s[p.pos])) ||
+			unicode.IsDigit(rune(p.s[p.pos])) || p.s[p.pos] == '_') {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	p.tok = p.s[start:p.pos]
+}
+
+// skipDigits advances past any digits and decimal points.
+func (p *exprParser) skipDigits() {
+	for p.pos < len(p.s) && (unicode.IsDigit(rune(p.s[p.pos])) || p.s[p.pos] == '.') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+	lhs, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok
+		p.next()
+		rhs, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryExpr(op, lhs, rhs)
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseProduct() (expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" || p.tok == "%" {
+		op := p.tok
+		p.next()
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryExpr(op, lhs, rhs)
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if p.tok == "-" {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string]float64) (float64, error) {
+			v, err := e(vars)
+			return -v, err
+		}, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *exprParser) parseTerm() (expr, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, errors.New("unexpected end of expression")
+	case tok == "(":
+		p.next()
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, errors.Errorf("expected ')' at %d", p.pos)
+		}
+		p.next()
+		return e, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, errors.Errorf("bad number '%s'", tok)
+		}
+		p.next()
+		return func(map[string]float64) (float64, error) { return v, nil }, nil
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_':
+		p.next()
+		if p.tok == "(" {
+			return p.parseCall(tok)
+		}
+		p.vars = append(p.vars, tok)
+		return func(vars map[string]float64) (float64, error) {
+			v, ok := vars[tok]
+			if !ok {
+				return 0, errors.Errorf("%s is not available", tok)
+			}
+			return v, nil
+		}, nil
+	}
+	return nil, errors.Errorf("unexpected '%s' at %d", tok, p.pos)
+}
+
+func (p *exprParser) parseCall(name string) (expr, error) {
+	f, ok := exprFuncs[name]
+	if !ok {
+		return nil, errors.Errorf("unknown function '%s'", name)
+	}
+	p.next()
+	var args []expr
+	if p.tok != ")" {
+		// each comma must be followed by another argument
+		for {
+			arg, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.tok == ")" {
+				break
+			}
+			if p.tok != "," {
+				return nil, errors.Errorf("expected ',' or ')' at %d", p.pos)
+			}
+			p.next()
+		}
+	}
+	p.next()
+	return func(vars map[string]float64) (float64, error) {
+		vv := make([]float64, len(args))
+		for i, arg := range args {
+			v, err := arg(vars)
+			if err != nil {
+				return 0, err
+			}
+			vv[i] = v
+		}
+		return f(vv)
+	}, nil
+}
+
+func binaryExpr(op string, lhs, rhs expr) expr {
+	return func(vars map[string]float64) (float64, error) {
+		l, err := lhs(vars)
+		if err != nil {
+			return 0, err
+		}
+		r, err := rhs(vars)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/":
+			if r == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return l / r, nil
+		default:
+			if r == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return math.Mod(l, r), nil
+		}
+	}
+}
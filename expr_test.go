@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompileExpr(t *testing.T) {
+	vars := map[string]float64{"a": 3, "b_2": 4, "zero": 0}
+	patterns := []struct {
+		name string
+		expr string
+		val  float64
+		vars []string
+	}{
+		{"number", "42", 42, nil},
+		{"decimal", ".5", 0.5, nil},
+		{"exponent", "1e3", 1000, nil},
+		{"negative exponent", "1e-3", 0.001, nil},
+		{"positive exponent", "2.5E+2", 250, nil},
+		{"exponent sum", "1e-3+1", 1.001, nil},
+		{"variable", "a", 3, []string{"a"}},
+		{"variables", "a * b_2", 12, []string{"a", "b_2"}},
+		{"precedence", "1 + 2 * 3", 7, nil},
+		{"left assoc", "8 - 4 - 2", 2, nil},
+		{"left assoc div", "8 / 4 / 2", 1, nil},
+		{"parens", "(1 + 2) * 3", 9, nil},
+		{"mod", "7 % 3", 1, nil},
+		{"unary minus", "-a", -3, []string{"a"}},
+		{"unary minus product", "-2 * 3", -6, nil},
+		{"double unary minus", "--2", 2, nil},
+		{"minus unary minus", "1 - -2", 3, nil},
+		{"abs", "abs(-2.5)", 2.5, nil},
+		{"min", "min(a, b_2, 1)", 1, []string{"a", "b_2"}},
+		{"max", "max(a, b_2)", 4, []string{"a", "b_2"}},
+		{"round", "round(2.5)", 3, nil},
+		{"round digits", "round(1.2345, 2)", 1.23, nil},
+		{"nested", "max(abs(-a), round(b_2 / 3, 1))", 3, []string{"a", "b_2"}},
+	}
+	for _, p := range patterns {
+		t.Run(p.name, func(t *testing.T) {
+			e, vv, err := compileExpr(p.expr)
+			if err != nil {
+				t.Fatalf("compile error: %v", err)
+			}
+			if !slices.Equal(vv, p.vars) {
+				t.Errorf("vars: got %v, want %v", vv, p.vars)
+			}
+			v, err := e(vars)
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if v != p.val {
+				t.Errorf("got %v, want %v", v, p.val)
+			}
+		})
+	}
+}
+
+func TestCompileExprError(t *testing.T) {
+	patterns := []struct {
+		name string
+		expr string
+	}{
+		{"empty", ""},
+		{"trailing operator", "1 +"},
+		{"unbalanced", "(1 + 2"},
+		{"extra paren", "1 + 2)"},
+		{"bad number", "1.2.3"},
+		{"bad exponent", "1e"},
+		{"unknown function", "sqrt(4)"},
+		{"missing comma", "min(1 2)"},
+		{"trailing comma", "min(a,)"},
+		{"leading comma", "min(,a)"},
+		{"unclosed call", "min(a,"},
+		{"unexpected operator", "* 2"},
+	}
+	for _, p := range patterns {
+		t.Run(p.name, func(t *testing.T) {
+			if _, _, err := compileExpr(p.expr); err == nil {
+				t.Errorf("expected error compiling '%s'", p.expr)
+			}
+		})
+	}
+}
+
+func TestExprEvalError(t *testing.T) {
+	vars := map[string]float64{"a": 3, "zero": 0}
+	patterns := []struct {
+		name string
+		expr string
+	}{
+		{"division by zero", "a / zero"},
+		{"mod by zero", "a % zero"},
+		{"missing variable", "a + b"},
+		{"abs arity", "abs(1, 2)"},
+		{"min arity", "min()"},
+		{"round arity", "round(1, 2, 3)"},
+		{"overflow", "1e308 * 10"},
+		{"round overflow", "round(a, 400)"},
+	}
+	for _, p := range patterns {
+		t.Run(p.name, func(t *testing.T) {
+			e, _, err := compileExpr(p.expr)
+			if err != nil {
+				t.Fatalf("compile error: %v", err)
+			}
+			if v, err := e(vars); err == nil {
+				t.Errorf("expected error, got %v", v)
+			}
+		})
+	}
+}
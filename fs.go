@@ -6,12 +6,15 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"log"
-	"os/exec"
+	"log/slog"
+	"maps"
+	"os"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -27,14 +30,18 @@ type mounts struct {
 type fsMountPointConfig struct {
 	pollerConfig `yaml:",inline"`
 	Path         string
+	Deadband     map[string]deadbandConfig
+	Thresholds   map[string]thresholdConfig
 }
 
 type fsConfig struct {
 	pollerConfig `yaml:",inline"`
 	Mountpoints  []string
+	Deadband     map[string]deadbandConfig
+	Thresholds   map[string]thresholdConfig
 }
 
-func newMounts(yamlCfg *yaml.Node) SyncCloser {
+func newMounts(mod *Module, yamlCfg *yaml.Node) SyncCloser {
 	cfg := fsConfig{pollerConfig: pollerConfig{Period: "10m"}}
 	// structured for fsConfig
 	err := yamlCfg.Decode(&cfg)
@@ -50,13 +57,17 @@ func newMounts(yamlCfg *yaml.Node) SyncCloser {
 
 	mm := []*mount{}
 	for _, name := range cfg.Mountpoints {
-		mCfg := fsMountPointConfig{pollerConfig: cfg.pollerConfig}
+		mCfg := fsMountPointConfig{
+			pollerConfig: cfg.pollerConfig,
+			Deadband:     maps.Clone(cfg.Deadband),
+			Thresholds:   maps.Clone(cfg.Thresholds),
+		}
 		yCfg := mpCfg[name]
 		err := yCfg.Decode(&mCfg)
 		if err != nil {
 			log.Fatalf("error reading fs %s config: %v", name, err)
 		}
-		mm = append(mm, newMount(name, &mCfg))
+		mm = append(mm, newMount(mod, name, &mCfg))
 	}
 	return &mounts{mm: mm}
 }
@@ -82,104 +93,154 @@ func (m *mounts) Sync(ps PubSub) {
 }
 
 func (m *mounts) Close() {
+	for _, mount := range m.mm {
+		mount.Close()
+	}
 }
 
 type mount struct {
 	PolledSensor
-	name    string
-	path    string
-	mounted bool
-	used    uint32
-	msg     string
-	cfg     []EntityConfig
+	name       string
+	path       string
+	mounted    bool
+	used       uint32
+	msg        string
+	stopic     string
+	deadbands  *deadbands
+	thresholds *thresholds
+	log        *slog.Logger
 }
 
-func newMount(name string, cfg *fsMountPointConfig) *mount {
-	m := mount{name: name, path: cfg.Path}
+func newMount(mod *Module, name string, cfg *fsMountPointConfig) *mount {
+	m := mount{
+		name:      name,
+		path:      cfg.Path,
+		deadbands: newDeadbands(cfg.Deadband),
+		log:       mod.log.With("mountpoint", name),
+	}
 	m.topic = "/" + name
-	m.poller = NewPoller(&cfg.pollerConfig, m.Refresh)
-	mtopic := "~/fs" + m.topic
+	m.poller = mod.NewPoller(&cfg.pollerConfig, m.Refresh)
+	m.stopic = mod.StateTopic(m.topic)
+	m.thresholds = newThresholds(cfg.Thresholds, []string{"used_percent"}, name+"_", m.topic, m.stopic)
+	return &m
+}
+
+func (m *mount) Config() []EntityConfig {
+	var config []EntityConfig
 	ecfg := map[string]any{
 		"name":           "fs " + m.name,
-		"state_topic":    mtopic,
+		"state_topic":    m.stopic,
 		"value_template": "{{value_json.mounted | is_defined}}",
 		"device_class":   "connectivity",
 		"icon":           "mdi:harddisk",
 		"payload_on":     "on",
 		"payload_off":    "off",
 	}
-	m.cfg = append(m.cfg, EntityConfig{m.name, "binary_sensor", ecfg})
+	config = append(config, EntityConfig{m.name, "binary_sensor", ecfg})
 	ecfg = map[string]any{
 		"name":                "fs " + m.name + " used percent",
-		"state_topic":         mtopic,
+		"state_topic":         m.stopic,
 		"value_template":      "{{(value_json.used_percent) | round(2)}}",
 		"unit_of_measurement": "%",
 		"icon":                "mdi:gauge",
 		"availability": []map[string]string{
 			{"topic": "~"},
-			{"topic": mtopic,
+			{"topic": m.stopic,
 				"value_template":        "{{value_json.mounted | is_defined | default('off')}}",
 				"payload_available":     "on",
 				"payload_not_available": "off",
 			},
-		}}
-	m.cfg = append(m.cfg, EntityConfig{m.name + "_used_percent", "sensor", ecfg})
-	return &m
+		},
+		"availability_mode": "all",
+	}
+	config = append(config, EntityConfig{m.name + "_used_percent", "sensor", ecfg})
+	config = append(config, m.thresholds.Config()...)
+	return config
 }
 
-func (m *mount) Config() []EntityConfig {
-	return m.cfg
-}
-
-func (m *mount) update() bool {
-	changed := false
-	cmd := exec.Command("df", m.path)
-	out, err := cmd.Output()
-	mounted := false
-	if err == nil {
-		r := bufio.NewReader(bytes.NewReader(out))
-		_, _, _ = r.ReadLine()
-		line, _, err := r.ReadLine()
+// update reads the current state of the mount point.
+// On error the previous state is retained.
+func (m *mount) update() {
+	mounted, err := isMountpoint(m.path)
+	if err != nil {
+		m.log.Warn("unable to read mounts", "err", err)
+		return
+	}
+	if mounted {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(hostPath(m.path), &st)
 		if err != nil {
-			log.Printf("error parsing df: %v", err)
-			return false
+			m.log.Warn("unable to stat fs", "err", err)
+			return
 		}
-		// split line on whitespace
-		fields := strings.Fields(string(line))
-		if len(fields) >= 6 && fields[5] == m.path {
-			mounted = true
-			total, err := strconv.ParseUint(fields[1], 10, 64)
-			if err != nil {
-				log.Printf("error parsing uint: %v", err)
-				return false
-			}
-			used, err := strconv.ParseUint(fields[2], 10, 64)
-			if err != nil {
-				log.Printf("error parsing uint: %v", err)
-				return false
-			}
-			usedPercent := uint32((used * 10000) / total)
-			if usedPercent != m.used {
-				m.used = usedPercent
-				changed = true
-			}
+		m.used = 0
+		if st.Blocks != 0 {
+			m.used = uint32(((st.Blocks - st.Bfree) * 10000) / st.Blocks)
 		}
 	}
-	if m.mounted != mounted {
-		changed = true
-		m.mounted = mounted
+	m.mounted = mounted
+}
+
+// isMountpoint returns true if the path is a mount point on the host.
+func isMountpoint(path string) (bool, error) {
+	// the host mounts are those seen by the host init process.
+	mpath := "/proc/self/mounts"
+	if len(hostRoot) > 0 {
+		mpath = hostPath("/proc/1/mounts")
+	}
+	f, err := os.Open(mpath)
+	if err != nil {
+		return false, err
 	}
-	return changed
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && unescapeMount(fields[1]) == path {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// unescapeMount reverts the octal escaping of whitespace and backslashes
+// in mount paths.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
 }
 
 func (m *mount) Publish() {
 	m.ps.Publish(m.topic, m.msg)
+	m.thresholds.Publish(m.ps)
 }
 
 func (m *mount) Refresh(forced bool) {
-	if !m.update() && !forced {
+	m.update()
+	values := map[string]float64{}
+	if m.mounted {
+		values["used_percent"] = float64(m.used) / 100
+	}
+	now := time.Now()
+	m.thresholds.update(m.ps, values, now)
+	if !forced && !m.deadbands.exceeded(values, now) {
 		return
 	}
+	m.deadbands.published(values, now)
 	vv := []string{}
 	if m.mounted {
 		vv = append(vv, `"mounted": "on"`)
@@ -189,5 +250,4 @@ func (m *mount) Refresh(forced bool) {
 	}
 	m.msg = fmt.Sprintf("{%s}", strings.Join(vv, ", "))
 	m.Publish()
-
 }
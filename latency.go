@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+type latencyConfig struct {
+	pollerConfig `yaml:",inline"`
+	// The number of probes sent to each target in a burst.
+	Count int
+	// The time between probes in a burst.
+	Interval string
+	// The time allowed for each probe to be answered.
+	Timeout string
+	// The targets to measure, keyed by entity name.
+	Targets map[string]latencyTargetConfig
+}
+
+type latencyTargetConfig struct {
+	// The probe type - icmp, udp or tcp.
+	Type string
+	// The host to ping with icmp probes.
+	Host string
+	// The host:port to send udp or tcp probes to.
+	Address string
+}
+
+// latency measures the round trip time and loss to a set of targets using
+// bursts of probes.
+type latency struct {
+	count    int
+	interval time.Duration
+	timeout  time.Duration
+	targets  []*latencyTarget
+}
+
+type latencyTarget struct {
+	name string
+	dial func(ctx context.Context) (rttProber, error)
+	msg  string
+}
+
+// rttProber sends probes to a target and times the responses.
+type rttProber interface {
+	rtt(seq uint16, deadline time.Time) (time.Duration, error)
+	Close() error
+}
+
+func newLatency(cfg *latencyConfig) *latency {
+	l := latency{count: cfg.Count}
+	if l.count < 1 {
+		log.Fatalf("wan latency count %d must be positive", cfg.Count)
+	}
+	var err error
+	l.interval, err = time.ParseDuration(cfg.Interval)
+	if err != nil {
+		log.Fatalf("error parsing wan latency interval '%s': %v", cfg.Interval, err)
+	}
+	l.timeout, err = time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		log.Fatalf("error parsing wan latency timeout '%s': %v", cfg.Timeout, err)
+	}
+	if len(cfg.Targets) == 0 {
+		log.Fatal("wan latency requires targets")
+	}
+	for name, tcfg := range cfg.Targets {
+		t := latencyTarget{name: name}
+		switch tcfg.Type {
+		case "icmp":
+			if len(tcfg.Host) == 0 {
+				log.Fatalf("wan latency target %s requires a host", name)
+			}
+			t.dial = func(ctx context.Context) (rttProber, error) {
+				return dialPing(ctx, tcfg.Host, probeBind{})
+			}
+		case "udp", "tcp":
+			if _, _, err := net.SplitHostPort(tcfg.Address); err != nil {
+				log.Fatalf("error parsing wan latency target %s address '%s': %v", name, tcfg.Address, err)
+			}
+			network := tcfg.Type
+			t.dial = func(context.Context) (rttProber, error) {
+				return &connProber{network: network, address: tcfg.Address}, nil
+			}
+		default:
+			log.Fatalf("unknown wan latency target %s type '%s'", name, tcfg.Type)
+		}
+		l.targets = append(l.targets, &t)
+	}
+	slices.SortFunc(l.targets, func(a, b *latencyTarget) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return &l
+}
+
+// measure sends a burst of probes to the target and returns the resulting
+// state message.
+//
+// The round trip times are in milliseconds, and are omitted if all probes
+// are lost.  The jitter is the mean difference between consecutive round
+// trip times.
+func (l *latency) measure(t *latencyTarget) string {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	p, err := t.dial(ctx)
+	cancel()
+	if err != nil {
+		return "{}"
+	}
+	defer p.Close()
+	rtts := []float64{}
+	for seq := range l.count {
+		if seq > 0 {
+			time.Sleep(l.interval)
+		}
+		rtt, err := p.rtt(uint16(seq+1), time.Now().Add(l.timeout))
+		if err == nil {
+			rtts = append(rtts, float64(rtt)/float64(time.Millisecond))
+		}
+	}
+	loss := float64(l.count-len(rtts)) * 100 / float64(l.count)
+	if len(rtts) == 0 {
+		return fmt.Sprintf(`{"loss": %.1f}`, loss)
+	}
+	rmin, rmax, sum, jitter := math.Inf(1), math.Inf(-1), 0.0, 0.0
+	for i, rtt := range rtts {
+		rmin = math.Min(rmin, rtt)
+		rmax = math.Max(rmax, rtt)
+		sum += rtt
+		if i > 0 {
+			jitter += math.Abs(rtt - rtts[i-1])
+		}
+	}
+	if len(rtts) > 1 {
+		jitter /= float64(len(rtts) - 1)
+	}
+	return fmt.Sprintf(`{"rtt_min": %.2f, "rtt_avg": %.2f, "rtt_max": %.2f, "jitter": %.2f, "loss": %.1f}`,
+		rmin, sum/float64(len(rtts)), rmax, jitter, loss)
+}
+
+func (c *pingConn) rtt(seq uint16, deadline time.Time) (time.Duration, error) {
+	return c.echo(seq, deadline)
+}
+
+// connProber times udp or tcp exchanges with a target.
+//
+// A tcp probe is timed to the completion of the connection handshake.
+// A udp probe is a DNS query, so the target must be a DNS server.
+type connProber struct {
+	network string
+	address string
+	conn    net.Conn
+}
+
+func (p *connProber) rtt(seq uint16, deadline time.Time) (time.Duration, error) {
+	d := net.Dialer{Deadline: deadline}
+	start := time.Now()
+	if p.network == "tcp" {
+		c, err := d.Dial("tcp", p.address)
+		if err != nil {
+			return 0, err
+		}
+		rtt := time.Since(start)
+		c.Close()
+		return rtt, nil
+	}
+	if p.conn == nil {
+		c, err := d.Dial("udp", p.address)
+		if err != nil {
+			return 0, err
+		}
+		p.conn = c
+	}
+	p.conn.SetDeadline(deadline)
+	// a recursive query for the root NS records
+	q := make([]byte, 12, 17)
+	binary.BigEndian.PutUint16(q[0:2], seq)
+	binary.BigEndian.PutUint16(q[2:4], 0x0100)
+	binary.BigEndian.PutUint16(q[4:6], 1)
+	q = append(q, 0, 0, 2, 0, 1)
+	start = time.Now()
+	if _, err := p.conn.Write(q); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := p.conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		// matching ID and a response
+		if n >= 12 && binary.BigEndian.Uint16(buf[0:2]) == seq && buf[2]&0x80 != 0 {
+			return time.Since(start), nil
+		}
+	}
+}
+
+func (p *connProber) Close() error {
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+type logConfig struct {
+	// The global log level.
+	Level string
+	// The log output format - text or json.
+	Format string
+	// Log levels for individual modules, overriding the global level.
+	Modules map[string]string
+}
+
+// logLevel is the global log level, which applies to all modules without
+// an overriding level.
+var logLevel slog.LevelVar
+
+// the shared handler for all loggers, which passes everything through,
+// with the filtering being performed by the levelHandler wrapping it.
+var logHandler slog.Handler
+
+func setupLogging(cfg *logConfig, verbose bool) {
+	if len(cfg.Level) > 0 {
+		level, err := parseLevel(cfg.Level)
+		if err != nil {
+			log.Fatalf("error parsing log.level '%s': %v", cfg.Level, err)
+		}
+		logLevel.Set(level)
+	}
+	if verbose {
+		logLevel.Set(slog.LevelDebug)
+	}
+	opts := slog.HandlerOptions{Level: slog.LevelDebug}
+	switch cfg.Format {
+	case "", "text":
+		// the init system is expected to timestamp log lines
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		}
+		logHandler = slog.NewTextHandler(os.Stderr, &opts)
+	case "json":
+		logHandler = slog.NewJSONHandler(os.Stderr, &opts)
+	default:
+		log.Fatalf("unsupported log.format: %s", cfg.Format)
+	}
+	slog.SetDefault(slog.New(levelHandler{&logLevel, logHandler}))
+}
+
+// parseLevel parses a log level name, e.g. debug or warn.
+func parseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return level, err
+}
+
+// levelHandler filters records below its level before passing them to the
+// wrapped handler.
+type levelHandler struct {
+	level slog.Leveler
+	h     slog.Handler
+}
+
+func (l levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= l.level.Level()
+}
+
+func (l levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return l.h.Handle(ctx, r)
+}
+
+func (l levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{l.level, l.h.WithAttrs(attrs)}
+}
+
+func (l levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{l.level, l.h.WithGroup(name)}
+}
+
+// moduleLevel is the log level for a module, which follows the global log
+// level unless overridden.
+type moduleLevel struct {
+	override atomic.Pointer[slog.Level]
+}
+
+// Level returns the effective log level for the module.
+func (m *moduleLevel) Level() slog.Level {
+	if l := m.override.Load(); l != nil {
+		return *l
+	}
+	return logLevel.Level()
+}
+
+// Set overrides the global log level for the module.
+func (m *moduleLevel) Set(level slog.Level) {
+	m.override.Store(&level)
+}
+
+// Reset reverts the module to the global log level.
+func (m *moduleLevel) Reset() {
+	m.override.Store(nil)
+}
+
+// newModuleLogger creates the logger for the named module.
+func newModuleLogger(name string, level *moduleLevel) *slog.Logger {
+	return slog.New(levelHandler{level, logHandler}).With("module", name)
+}
+
+// setLogLevel handles a request to update the global log level.
+func setLogLevel(ps PubSub, b []byte) {
+	level, err := parseLevel(string(b))
+	if err != nil {
+		slog.Warn("invalid log level", "level", string(b), "err", err)
+		return
+	}
+	logLevel.Set(level)
+	ps.Publish("/log_level", level)
+}
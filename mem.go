@@ -8,9 +8,11 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -25,16 +27,22 @@ type mem struct {
 	PolledSensor
 	entities map[string]bool
 	// mem and swap used percent as calced from /proc/meminfo
-	stats memStats
-	msg   string
+	stats      memStats
+	msg        string
+	stopic     string
+	deadbands  *deadbands
+	thresholds *thresholds
+	log        *slog.Logger
 }
 
 type memConfig struct {
 	pollerConfig `yaml:",inline"`
 	Entities     []string
+	Deadband     map[string]deadbandConfig
+	Thresholds   map[string]thresholdConfig
 }
 
-func newMem(yamlCfg *yaml.Node) SyncCloser {
+func newMem(mod *Module, yamlCfg *yaml.Node) SyncCloser {
 	cfg := memConfig{
 		pollerConfig: pollerConfig{Period: "1m"},
 		Entities:     []string{"ram_used_percent", "swap_used_percent"},
@@ -49,18 +57,28 @@ func newMem(yamlCfg *yaml.Node) SyncCloser {
 	}
 	stats, err := newMemStats(entities)
 	if err != nil {
-		log.Fatalf("unable to read mem stats: %v", err)
+		// not a config error, so leave it to the module to retry
+		panic(fmt.Sprintf("unable to read mem stats: %v", err))
 	}
-	m := mem{entities: entities, stats: stats}
-	m.poller = NewPoller(&cfg.pollerConfig, m.Refresh)
+	m := mem{
+		entities:  entities,
+		stats:     stats,
+		stopic:    mod.StateTopic(""),
+		deadbands: newDeadbands(cfg.Deadband),
+		log:       mod.log,
+	}
+	m.thresholds = newThresholds(cfg.Thresholds, []string{"ram_used_percent", "swap_used_percent"},
+		"", m.topic, m.stopic)
+	m.poller = mod.NewPoller(&cfg.pollerConfig, m.Refresh)
 	return &m
 }
 
 func newMemStats(fields map[string]bool) (memStats, error) {
 	names := []string{"MemTotal:", "MemAvailable:", "SwapTotal:", "SwapFree:"}
 	stats := [4]uint64{}
+	// only stats that could be determined are included
 	ms := memStats{}
-	f, err := os.Open("/proc/meminfo")
+	f, err := os.Open(hostPath("/proc/meminfo"))
 	if err != nil {
 		return ms, err
 	}
@@ -96,45 +114,50 @@ func (m *mem) Config() []EntityConfig {
 	if m.entities["ram_used_percent"] {
 		cfg := map[string]any{
 			"name":                "RAM used percent",
-			"state_topic":         "~/mem",
+			"state_topic":         m.stopic,
 			"value_template":      "{{value_json.ram_used_percent | is_defined}}",
 			"unit_of_measurement": "%",
 			"icon":                "mdi:gauge",
 		}
+		setFieldAvailability(cfg, m.stopic, "ram_used_percent")
 		config = append(config, EntityConfig{"ram_used_percent", "sensor", cfg})
 	}
 	if m.entities["swap_used_percent"] {
 		cfg := map[string]any{
 			"name":                "swap used percent",
-			"state_topic":         "~/mem",
+			"state_topic":         m.stopic,
 			"value_template":      "{{value_json.swap_used_percent | is_defined}}",
 			"unit_of_measurement": "%",
 			"icon":                "mdi:gauge",
 		}
+		setFieldAvailability(cfg, m.stopic, "swap_used_percent")
 		config = append(config, EntityConfig{"swap_used_percent", "sensor", cfg})
 	}
+	config = append(config, m.thresholds.Config()...)
 	return config
 }
 
 func (m *mem) Publish() {
 	m.ps.Publish(m.topic, m.msg)
+	m.thresholds.Publish(m.ps)
 }
 
 func (m *mem) Refresh(forced bool) {
 	stats, err := newMemStats(m.entities)
 	if err != nil {
-		log.Printf("unable to read mem stats: %v", err)
-		return
+		m.log.Warn("unable to read mem stats", "err", err)
 	}
 
-	var changed = forced
-	for k := range stats {
-		if stats[k] != m.stats[k] {
-			changed = true
-		}
+	// fields missing from stats are unavailable
+	values := map[string]float64{}
+	for k, v := range stats {
+		values[k] = float64(v)
 	}
+	now := time.Now()
 	m.stats = stats
-	if changed {
+	m.thresholds.update(m.ps, values, now)
+	if forced || m.deadbands.exceeded(values, now) {
+		m.deadbands.published(values, now)
 		fields := []string{}
 		for k, v := range m.stats {
 			fields = append(fields, fmt.Sprintf(`"%s": %.2f`, k, v))
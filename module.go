@@ -0,0 +1,274 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 5 * time.Minute
+)
+
+// panicCount is the number of panics recovered since startup.
+var panicCount atomic.Uint64
+
+// Module supervises an instance of a module.
+//
+// Panics in the module's poller and subscription callbacks are recovered,
+// and the module is marked unavailable and restarted with backoff, so
+// a fault in one module does not take down the whole daemon.
+type Module struct {
+	name    string
+	factory ModuleFactory
+	cfg     yaml.Node
+	done    chan struct{}
+	log     *slog.Logger
+	level   moduleLevel
+
+	mu sync.Mutex
+	sc SyncCloser
+	ps PubSub
+	// failed is set from a panic until the module has been restarted.
+	failed bool
+	// retry is set if the instance being restarted also panics.
+	retry     bool
+	backoff   time.Duration
+	lastPanic time.Time
+}
+
+func newModule(name string, factory ModuleFactory, cfg *yaml.Node) *Module {
+	m := Module{
+		name:    name,
+		factory: factory,
+		cfg:     *cfg,
+		done:    make(chan struct{}),
+		ps:      StubPubSub{},
+		backoff: minRestartBackoff,
+	}
+	m.log = newModuleLogger(name, &m.level)
+	// config errors are fatal, but runtime faults, such as being unable to
+	// read initial stats, are retried like any other panic.
+	if !safeCall(m.log, func() { m.sc = factory(&m, &m.cfg) }) {
+		m.failed = true
+		m.lastPanic = time.Now()
+		go m.restart(nil)
+	}
+	return &m
+}
+
+// NewPoller creates a Poller for the module, with panics in the polled
+// function isolated to the module.
+func (m *Module) NewPoller(cfg *pollerConfig, f func(bool)) *Poller {
+	return NewPoller(cfg, func(forced bool) {
+		m.guard(func() { f(forced) })
+	})
+}
+
+// StateTopic returns the topic, relative to the base topic of the daemon, of
+// a module state topic, for use in entity config.
+func (m *Module) StateTopic(topic string) string {
+	return "~/" + m.name + topic
+}
+
+// Config returns the entity config of the supervised module.
+func (m *Module) Config() []EntityConfig {
+	m.mu.Lock()
+	sc := m.sc
+	m.mu.Unlock()
+	if d, ok := sc.(discoverable); ok {
+		return d.Config()
+	}
+	return nil
+}
+
+// Publish publishes the availability and current state of the module.
+func (m *Module) Publish() {
+	m.mu.Lock()
+	sc, ps, failed := m.sc, m.ps, m.failed
+	m.mu.Unlock()
+	ps.Publish("/availability", onlineString(!failed))
+	if !failed {
+		m.guard(sc.Publish)
+	}
+}
+
+// Sync binds the module to the PubSub.
+func (m *Module) Sync(ps PubSub) {
+	m.mu.Lock()
+	m.ps = ps
+	sc, failed := m.sc, m.failed
+	m.mu.Unlock()
+	ps.Publish("/availability", onlineString(!failed))
+	ps.Publish("/log_level", m.level.Level())
+	ps.Subscribe("/rqd/log_level", m.SetLogLevel)
+	if !failed {
+		m.guard(func() { sc.Sync(guardedPubSub{ps, m}) })
+	}
+}
+
+// Close shuts down the module, cancelling any pending restart.
+func (m *Module) Close() {
+	close(m.done)
+	m.mu.Lock()
+	sc, failed := m.sc, m.failed
+	m.mu.Unlock()
+	if !failed {
+		sc.Close()
+	}
+}
+
+// SetLogLevel handles a request to update the log level of the module.
+// An empty level reverts the module to the global log level.
+func (m *Module) SetLogLevel(b []byte) {
+	if len(strings.TrimSpace(string(b))) == 0 {
+		m.level.Reset()
+	} else {
+		level, err := parseLevel(string(b))
+		if err != nil {
+			m.log.Warn("invalid log level", "level", string(b), "err", err)
+			return
+		}
+		m.level.Set(level)
+	}
+	m.mu.Lock()
+	ps := m.ps
+	m.mu.Unlock()
+	ps.Publish("/log_level", m.level.Level())
+}
+
+// guard calls f, recovering any panic and restarting the module.
+func (m *Module) guard(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(m.log, r)
+			m.panicked()
+		}
+	}()
+	f()
+}
+
+func (m *Module) panicked() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed {
+		// restart already pending, so the new instance is faulty too
+		m.retry = true
+		return
+	}
+	m.failed = true
+	if time.Since(m.lastPanic) > maxRestartBackoff {
+		// stable since the last panic, so start over
+		m.backoff = minRestartBackoff
+	}
+	m.lastPanic = time.Now()
+	m.ps.Publish("/availability", onlineString(false))
+	go m.restart(m.sc)
+}
+
+// restart replaces the failed module with a new instance, retrying with
+// increasing backoff until the new instance is successfully synced.
+func (m *Module) restart(old SyncCloser) {
+	if old != nil {
+		safeCall(m.log, old.Close)
+	}
+	for {
+		m.log.Info("restarting", "backoff", m.backoff)
+		select {
+		case <-time.After(m.backoff):
+		case <-m.done:
+			return
+		}
+		m.backoff = min(2*m.backoff, maxRestartBackoff)
+		var sc SyncCloser
+		m.mu.Lock()
+		ps := m.ps
+		m.retry = false
+		m.mu.Unlock()
+		ok := safeCall(m.log, func() {
+			sc = m.factory(m, &m.cfg)
+			sc.Sync(guardedPubSub{ps, m})
+		})
+		m.mu.Lock()
+		if !ok || m.retry {
+			m.mu.Unlock()
+			if sc != nil {
+				safeCall(m.log, sc.Close)
+			}
+			continue
+		}
+		select {
+		case <-m.done:
+			m.mu.Unlock()
+			sc.Close()
+			return
+		default:
+		}
+		m.sc = sc
+		m.failed = false
+		// pick up any PubSub change during the restart
+		ps = m.ps
+		m.mu.Unlock()
+		m.log.Info("restarted")
+		ps.Publish("/availability", onlineString(true))
+		// the entities may differ from the failed instance, or it may not
+		// have had any if it failed at startup
+		rediscover()
+		return
+	}
+}
+
+// safeCall calls f, recovering and logging any panic.
+// Returns false if f panicked.
+func safeCall(logger *slog.Logger, f func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(logger, r)
+			ok = false
+		}
+	}()
+	f()
+	return true
+}
+
+func logPanic(logger *slog.Logger, r any) {
+	panicCount.Add(1)
+	logger.Error("panic", "panic", r, "stack", string(debug.Stack()))
+}
+
+// moduleAvailability returns the availability topic for the named module.
+func moduleAvailability(name string) string {
+	return fmt.Sprintf("~/%s/availability", name)
+}
+
+// guardedPubSub isolates panics in subscription callbacks to the module.
+//
+// It also shares the states published by the module with other modules.
+type guardedPubSub struct {
+	PubSub
+	m *Module
+}
+
+// Publish publishes to a topic, and to any listeners for module states.
+func (g guardedPubSub) Publish(topic string, value any) {
+	g.PubSub.Publish(topic, value)
+	states.publish(g.m.name+topic, value)
+}
+
+// Subscribe subscribes to a topic, with panics in the callback recovered.
+func (g guardedPubSub) Subscribe(topic string, callback func([]byte)) {
+	g.PubSub.Subscribe(topic, func(b []byte) {
+		g.m.guard(func() { callback(b) })
+	})
+}
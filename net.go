@@ -7,12 +7,21 @@ package main
 import (
 	"fmt"
 	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
 	"os"
+	"path"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
@@ -21,13 +30,36 @@ func init() {
 }
 
 type nets struct {
+	mod   *Module
+	cfg   netConfig
+	ifCfg map[string]yaml.Node
+	// patterns selecting the interfaces to monitor, other than those listed
+	include []ifPattern
+	exclude []ifPattern
+	// periodically rescans for interfaces, in case of missed notifications
+	scanner  *Poller
+	unlisten func()
+
+	mu sync.Mutex
 	nn []*netIf
+	ps PubSub
+	// set once synced, after which added interfaces must be synced and
+	// advertised
+	synced bool
 }
 
 type netConfig struct {
 	pollerConfig `yaml:",inline"`
 	Entities     []string
 	Interfaces   []string
+	Include      []string
+	Exclude      []string
+	Scan         pollerConfig
+	Deadband     map[string]deadbandConfig
+	Sample       sampleConfig
+	Thresholds   map[string]thresholdConfig
+	Usage        usageConfig
+	Wireless     pollerConfig
 }
 
 type netIfConfig struct {
@@ -35,9 +67,14 @@ type netIfConfig struct {
 	Entities     []string
 	Link         pollerConfig
 	Stats        pollerConfig
+	Deadband     map[string]deadbandConfig
+	Sample       sampleConfig
+	Thresholds   map[string]thresholdConfig
+	Usage        usageConfig
+	Wireless     pollerConfig
 }
 
-func newNets(yamlCfg *yaml.Node) SyncCloser {
+func newNets(mod *Module, yamlCfg *yaml.Node) SyncCloser {
 	cfg := netConfig{
 		pollerConfig: pollerConfig{Period: "1m"},
 		Entities: []string{
@@ -59,81 +96,302 @@ func newNets(yamlCfg *yaml.Node) SyncCloser {
 	if err != nil {
 		log.Fatalf("error parsing net if config: %v", err)
 	}
-	// mounts may inherit period and entities
-	nn := []*netIf{}
+	n := nets{
+		mod:     mod,
+		cfg:     cfg,
+		ifCfg:   ifCfg,
+		include: newIfPatterns(cfg.Include),
+		exclude: newIfPatterns(cfg.Exclude),
+		ps:      StubPubSub{},
+	}
 	for _, name := range cfg.Interfaces {
-		mCfg := netIfConfig{
-			pollerConfig: cfg.pollerConfig,
-			Entities:     cfg.Entities,
-		}
-		yCfg := ifCfg[name]
-		err := yCfg.Decode(&mCfg)
-		if err != nil {
-			log.Fatalf("error reading net %s config: %v", name, err)
+		n.nn = append(n.nn, n.newNetIf(name))
+	}
+	if len(n.include) > 0 {
+		n.scan(false)
+		if len(cfg.Scan.Period) == 0 {
+			cfg.Scan.Period = cfg.Period
 		}
+		n.scanner = mod.NewPoller(&cfg.Scan, n.scan)
+		n.unlisten = linkEvents.listen(func(string) {
+			n.scanner.RefreshAsync()
+		})
+	}
+	return &n
+}
 
-		nn = append(nn, newNetIf(name, &mCfg))
+// newNetIf creates a netIf for the named interface.
+func (n *nets) newNetIf(name string) *netIf {
+	// interfaces may inherit period and entities
+	cfg := n.cfg
+	mCfg := netIfConfig{
+		pollerConfig: cfg.pollerConfig,
+		Entities:     cfg.Entities,
+		Deadband:     maps.Clone(cfg.Deadband),
+		Sample:       cfg.Sample,
+		Thresholds:   maps.Clone(cfg.Thresholds),
+		Usage:        cfg.Usage,
+		Wireless:     cfg.Wireless,
+	}
+	yCfg := n.ifCfg[name]
+	err := yCfg.Decode(&mCfg)
+	if err != nil {
+		log.Fatalf("error reading net %s config: %v", name, err)
 	}
-	return &nets{nn: nn}
+	return newNetIf(n.mod, name, &mCfg)
+}
+
+// scan adds interfaces that have appeared and match the include patterns,
+// and removes those that have disappeared.
+//
+// Interfaces listed explicitly are never removed.
+func (n *nets) scan(_ bool) {
+	entries, err := os.ReadDir(hostPath("/sys/class/net"))
+	if err != nil {
+		n.mod.log.Warn("unable to scan interfaces", "err", err)
+		return
+	}
+	present := map[string]bool{}
+	for _, e := range entries {
+		name := e.Name()
+		if !slices.Contains(n.cfg.Interfaces, name) &&
+			matchIfPatterns(n.include, name) && !matchIfPatterns(n.exclude, name) {
+			present[name] = true
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	nn := []*netIf{}
+	removed := []*netIf{}
+	for _, netif := range n.nn {
+		if slices.Contains(n.cfg.Interfaces, netif.name) || present[netif.name] {
+			nn = append(nn, netif)
+			delete(present, netif.name)
+		} else {
+			removed = append(removed, netif)
+		}
+	}
+	added := []*netIf{}
+	for _, name := range slices.Sorted(maps.Keys(present)) {
+		added = append(added, n.newNetIf(name))
+	}
+	n.nn = append(nn, added...)
+	for _, netif := range removed {
+		n.mod.log.Info("interface removed", "interface", netif.name)
+		netif.Close()
+	}
+	for _, netif := range added {
+		n.mod.log.Info("interface added", "interface", netif.name)
+	}
+	if !n.synced || (len(added) == 0 && len(removed) == 0) {
+		return
+	}
+	// Synced under the lock so a concurrent nets.Sync cannot sync the added
+	// interfaces with a different PubSub.
+	for _, netif := range added {
+		netif.Sync(n.ps)
+	}
+	// the initial states of added interfaces are published before they are
+	// advertised, so publish them again once they are
+	rediscover(func() {
+		for _, netif := range added {
+			netif.publish()
+		}
+	})
+}
+
+func (n *nets) netIfs() []*netIf {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Clone(n.nn)
 }
 
 func (n *nets) Config() []EntityConfig {
 	var config []EntityConfig
-	for _, netif := range n.nn {
+	for _, netif := range n.netIfs() {
 		config = append(config, netif.Config()...)
 	}
 	return config
 }
 
 func (n *nets) Publish() {
-	for _, netif := range n.nn {
+	for _, netif := range n.netIfs() {
 		netif.publish()
 	}
 }
 
 func (n *nets) Sync(ps PubSub) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.ps = ps
+	n.synced = true
 	for _, netif := range n.nn {
 		netif.Sync(ps)
 	}
 }
 
 func (n *nets) Close() {
+	if n.scanner != nil {
+		n.unlisten()
+		n.scanner.Close()
+	}
+	for _, netif := range n.netIfs() {
+		netif.Close()
+	}
+}
+
+// ifPattern matches interface names, either as a glob or, if enclosed in
+// slashes, a regular expression.
+type ifPattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func newIfPatterns(patterns []string) []ifPattern {
+	pp := []ifPattern{}
+	for _, p := range patterns {
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				log.Fatalf("error parsing net interface pattern '%s': %v", p, err)
+			}
+			pp = append(pp, ifPattern{re: re})
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			log.Fatalf("error parsing net interface pattern '%s': %v", p, err)
+		}
+		pp = append(pp, ifPattern{glob: p})
+	}
+	return pp
+}
+
+// matchIfPatterns returns true if the name matches any of the patterns.
+func matchIfPatterns(pp []ifPattern, name string) bool {
+	for _, p := range pp {
+		if p.re != nil {
+			if p.re.MatchString(name) {
+				return true
+			}
+		} else if ok, _ := path.Match(p.glob, name); ok {
+			return true
+		}
+	}
+	return false
 }
 
 type gauge struct {
 	valid bool
 	value uint64
+	// the counter is known to be 64-bit
+	wide bool
 }
 
 func (g gauge) delta(new gauge) uint64 {
-	if g.valid && new.valid && (g.value < new.value) {
-		return new.value - g.value
+	if g.valid && new.valid {
+		return counterDelta(g.value, new.value, new.wide)
 	}
 	return 0
 }
 
+// kernel64 is true if the kernel is 64-bit, so all interface counters are
+// 64-bit.
+var kernel64 = func() bool {
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		return false
+	}
+	m := utsString(u.Machine[:])
+	return strings.Contains(m, "64") || m == "s390x"
+}()
+
+// counterDelta returns the increase in a counter from old to new.
+//
+// A decrease is taken to be the wrap of a 32-bit counter, as found on 32-bit
+// kernels, unless the counter is known to be 64-bit, either as the kernel is
+// 64-bit or as the counter has exceeded 32 bits, in which case it is a reset
+// of the counter and the increase is the new value.
+func counterDelta(old, new uint64, wide bool) uint64 {
+	if new >= old {
+		return new - old
+	}
+	if wide || kernel64 || old > math.MaxUint32 {
+		return new
+	}
+	return math.MaxUint32 - old + new + 1
+}
+
 func (g gauge) rate(new gauge, td time.Duration) float64 {
 	return float64(g.delta(new)) / td.Seconds()
 }
 
-type link struct {
-	operstate string
-	carrier   string
+// counter is the lifetime total of an interface counter.
+type counter struct {
+	Total uint64 `json:"total"`
+	// the raw counter value when the total was last updated
+	Last uint64 `json:"last"`
+	// the raw counter is known to be 64-bit
+	Wide bool `json:"wide,omitempty"`
+}
+
+// netTotals are the lifetime totals of the interface counters, persisted
+// so they survive restarts of dunnart, reboots, and the interface being
+// recreated.
+type netTotals struct {
+	// the boot and interface the Last counter values apply to
+	BootID   string              `json:"boot_id"`
+	IfIndex  string              `json:"ifindex"`
+	Counters map[string]*counter `json:"counters"`
+	// data used in the current day and billing month
+	Day   usagePeriod `json:"day"`
+	Month usagePeriod `json:"month"`
+}
+
+// restart restarts the tracking of the raw counters, after they have been
+// reset by a reboot or the interface being recreated.
+func (t *netTotals) restart() {
+	for _, c := range t.Counters {
+		c.Last = 0
+	}
 }
 
 type netIf struct {
 	name          string
 	statsEntities map[string]bool
 	linkEntities  map[string]bool
-	link          link
-	online        bool
-	linkPoller    *PolledSensor
-	statsPoller   *PolledSensor
-	ps            PubSub
-	gauges        map[string]gauge
-	lastTime      time.Time
-	linkMsg       string
-	statsMsg      string
+	// link properties, as JSON values, keyed by entity.
+	// Properties that could not be read are omitted.
+	link        map[string]string
+	linkPoller  *PolledSensor
+	statsPoller *PolledSensor
+	ps          PubSub
+	gauges      map[string]gauge
+	lastTime    time.Time
+	linkMsg     string
+	statsMsg    string
+	stopic      string
+	deadbands   *deadbands
+	thresholds  *thresholds
+	// sampling of rates between publishes
+	sampler      *Poller
+	agg          *aggregator
+	sampleGauges map[string]gauge
+	sampleTime   time.Time
+	// lifetime totals and usage
+	totalsMu    sync.Mutex
+	totals      *netTotals
+	totalGauges map[string]bool
+	usage       *usage
+	state       *stateFile
+	log         *slog.Logger
+	// removes the listener for link change notifications
+	unlisten func()
+	// wireless state, as JSON values, keyed by entity
+	wirelessEntities map[string]bool
+	wirelessPoller   *PolledSensor
+	nl80211          nl80211Conn
+	wireless         map[string]string
+	wirelessMsg      string
 }
 
 func (n *netIf) publish() {
@@ -143,6 +401,9 @@ func (n *netIf) publish() {
 	if n.statsPoller != nil {
 		n.publishStats()
 	}
+	if n.wirelessPoller != nil {
+		n.publishWireless()
+	}
 }
 
 func (n *netIf) publishLink() {
@@ -151,38 +412,137 @@ func (n *netIf) publishLink() {
 
 func (n *netIf) publishStats() {
 	n.ps.Publish("/"+n.name+"/stats", n.statsMsg)
+	n.thresholds.Publish(n.ps)
 }
 
-func (n *netIf) RefreshLink(forced bool) {
-	changed := forced
-	if n.linkEntities["operstate"] {
-		opst := n.readStatus("operstate")
-		if n.link.operstate != opst {
-			changed = true
-			n.link.operstate = opst
+func (n *netIf) publishWireless() {
+	n.ps.Publish("/"+n.name+"/wireless", n.wirelessMsg)
+}
+
+func (n *netIf) RefreshWireless(forced bool) {
+	w := readWireless(&n.nl80211, n.name)
+	for e := range w {
+		if !n.wirelessEntities[e] {
+			delete(w, e)
 		}
 	}
-	if n.linkEntities["carrier"] {
-		c := n.readStatus("carrier")
-		if n.link.carrier != c {
-			changed = true
-			n.link.carrier = c
+	if forced || !maps.Equal(w, n.wireless) {
+		n.wireless = w
+		fields := []string{}
+		for _, e := range wirelessEntities {
+			if v, ok := w[e]; ok {
+				fields = append(fields, fmt.Sprintf(`"%s": %s`, e, v))
+			}
 		}
+		n.wirelessMsg = fmt.Sprintf("{%s}", strings.Join(fields, ", "))
+		n.publishWireless()
 	}
-	if changed {
-		fields := []string{}
-		if n.linkEntities["operstate"] {
-			fields = append(fields, fmt.Sprintf(`"operstate": "%s"`, n.link.operstate))
+}
+
+func (n *netIf) RefreshLink(forced bool) {
+	link := map[string]string{}
+	for _, e := range linkEntities {
+		if n.linkEntities[e] {
+			if v, ok := n.readLinkProp(e); ok {
+				link[e] = v
+			}
 		}
-		if n.linkEntities["carrier"] {
-			fields = append(fields, fmt.Sprintf(`"carrier": "%s"`, n.link.carrier))
+	}
+	if forced || !maps.Equal(link, n.link) {
+		n.link = link
+		fields := []string{}
+		for _, e := range linkEntities {
+			if v, ok := link[e]; ok {
+				fields = append(fields, fmt.Sprintf(`"%s": %s`, e, v))
+			}
 		}
 		n.linkMsg = fmt.Sprintf("{%s}", strings.Join(fields, ", "))
 		n.publishLink()
 	}
 }
 
-func (n *netIf) RefreshStats(_ bool) {
+// readLinkProp reads a link property, returning it as a JSON value.
+func (n *netIf) readLinkProp(e string) (string, bool) {
+	switch e {
+	case "ipv4", "ipv6":
+		return ifAddr(n.name, e == "ipv6")
+	case "mac":
+		v, ok := n.readStatus("address")
+		return strconv.Quote(v), ok
+	case "mtu", "speed":
+		// speed is invalid, or -1, while the link is down
+		v, ok := n.readStatus(e)
+		if i, err := strconv.Atoi(v); ok && err == nil && i >= 0 {
+			return v, true
+		}
+		return "", false
+	}
+	v, ok := n.readStatus(e)
+	return strconv.Quote(v), ok
+}
+
+// ifAddr returns the primary IPv4 or IPv6 address of the interface, as a
+// JSON value.
+//
+// Link-local addresses are ignored.
+func ifAddr(name string, ipv6 bool) (string, bool) {
+	iface, err := hostInterface(name)
+	if err != nil {
+		return "", false
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", false
+	}
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipv6 == (ipn.IP.To4() == nil) {
+			return strconv.Quote(ipn.IP.String()), true
+		}
+	}
+	return "", false
+}
+
+// hostInterface returns the network interface with the name on the host.
+//
+// Interfaces are found in the host sysfs, but addresses and netlink queries
+// are made in dunnart's own network namespace, which only contains the host
+// interfaces if a container uses the host network.  So an interface is only
+// returned if its index and MAC match those in the host sysfs, rather than
+// reporting on an unrelated interface of the same name.
+func hostInterface(name string) (*net.Interface, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	ipath := hostPath(path.Join("/sys/class/net", name))
+	v, err := os.ReadFile(path.Join(ipath, "ifindex"))
+	if err != nil {
+		return nil, err
+	}
+	idx, err := strconv.Atoi(strings.TrimSpace(string(v)))
+	if err != nil {
+		return nil, err
+	}
+	v, err = os.ReadFile(path.Join(ipath, "address"))
+	if err != nil {
+		return nil, err
+	}
+	// an all zero address, such as loopback, is reported as no address.
+	mac := strings.TrimSpace(string(v))
+	if strings.Trim(mac, "0:") == "" {
+		mac = ""
+	}
+	if idx != iface.Index || mac != iface.HardwareAddr.String() {
+		return nil, errors.Errorf("%s is not the host interface - the host network is required", name)
+	}
+	return iface, nil
+}
+
+func (n *netIf) RefreshStats(forced bool) {
 	oldg := map[string]gauge{}
 	t := time.Now()
 	var elapsed time.Duration
@@ -192,58 +552,162 @@ func (n *netIf) RefreshStats(_ bool) {
 	n.lastTime = t
 	for gname := range n.gauges {
 		oldg[gname] = n.gauges[gname]
-		n.gauges[gname] = n.readGauge(gname)
+		n.gauges[gname] = n.readGauge(gname, oldg[gname])
+	}
+	if n.totals != nil {
+		n.updateTotals(t)
 	}
+	// gauges that could not be read are omitted, as are their rates
 	fields := []string{}
+	values := map[string]float64{}
 	for _, gname := range statsGauges {
-		if n.statsEntities[gname] {
+		if n.statsEntities[gname] && n.gauges[gname].valid {
 			fields = append(fields, fmt.Sprintf(`"%s": %d`, gname, n.gauges[gname].value))
+			values[gname] = float64(n.gauges[gname].value)
+		}
+	}
+	for _, tot := range statsTotals {
+		if n.statsEntities[tot.total] {
+			if c, ok := n.totals.Counters[tot.gauge]; ok {
+				fields = append(fields, fmt.Sprintf(`"%s": %d`, tot.total, c.Total))
+				values[tot.total] = float64(c.Total)
+			}
+		}
+	}
+	if n.usage != nil {
+		day, month := n.totals.Day.Bytes, n.totals.Month.Bytes
+		if n.statsEntities["day_usage"] {
+			fields = append(fields, fmt.Sprintf(`"day_usage": %d`, day))
+		}
+		if n.statsEntities["month_usage"] {
+			fields = append(fields, fmt.Sprintf(`"month_usage": %d`, month))
+		}
+		values["day_usage"] = float64(day)
+		values["month_usage"] = float64(month)
+		if n.usage.quota > 0 {
+			remaining := n.usage.remaining(month)
+			if n.statsEntities["quota_remaining"] {
+				fields = append(fields, fmt.Sprintf(`"quota_remaining": %d`, remaining))
+			}
+			values["quota_remaining"] = float64(remaining)
 		}
 	}
 	for _, r := range statsRates {
-		if n.statsEntities[r.rate] {
+		if n.statsEntities[r.rate] && n.gauges[r.gauge].valid {
 			rate := float64(0)
 			if elapsed > 0 {
+				if !oldg[r.gauge].valid {
+					continue
+				}
 				rate = oldg[r.gauge].rate(n.gauges[r.gauge], elapsed) * r.scaling
 			}
 			fields = append(fields, fmt.Sprintf(`"%s": %0.2f`, r.rate, rate))
+			values[r.rate] = rate
 		}
 	}
+	sum := n.agg.take()
+	maps.Copy(values, sum)
+	n.thresholds.update(n.ps, values, t)
+	if !forced && !n.deadbands.exceeded(values, t) {
+		return
+	}
+	n.deadbands.published(values, t)
+	fields = append(fields, summaryFields(sum)...)
 	n.statsMsg = fmt.Sprintf("{%s}", strings.Join(fields, ", "))
 	n.publishStats()
 }
 
-func (n *netIf) readStatus(fname string) string {
-	v, err := os.ReadFile("/sys/class/net/" + n.name + "/" + fname)
+// updateTotals adds the change in the gauges to the lifetime totals, and the
+// change in bytes to the usage.
+func (n *netIf) updateTotals(now time.Time) {
+	n.totalsMu.Lock()
+	defer n.totalsMu.Unlock()
+	if ifindex, ok := n.readStatus("ifindex"); ok && ifindex != n.totals.IfIndex {
+		n.totals.restart()
+		n.totals.IfIndex = ifindex
+	}
+	var used uint64
+	for _, gname := range statsGauges {
+		g := n.gauges[gname]
+		if !n.totalGauges[gname] || !g.valid {
+			continue
+		}
+		c, ok := n.totals.Counters[gname]
+		if !ok {
+			c = &counter{}
+			n.totals.Counters[gname] = c
+		}
+		c.Wide = c.Wide || g.wide
+		d := counterDelta(c.Last, g.value, c.Wide)
+		c.Total += d
+		c.Last = g.value
+		// traffic prior to the first reading may predate the usage periods
+		if ok && strings.HasSuffix(gname, "_bytes") {
+			used += d
+		}
+	}
+	rolled := false
+	if n.usage != nil {
+		rolled = n.totals.Day.add(n.usage.dayStart(now), used)
+		rolled = n.totals.Month.add(n.usage.monthStart(now), used) || rolled
+	}
+	// save the final usage of a period when it ends, rather than up to
+	// save_period later.
+	if err := n.state.save(n.totals, rolled); err != nil {
+		n.log.Warn("unable to save totals", "err", err)
+	}
+}
+
+func (n *netIf) readStatus(fname string) (string, bool) {
+	v, err := os.ReadFile(hostPath("/sys/class/net/" + n.name + "/" + fname))
 	if err == nil {
-		return strings.TrimSpace(string(v))
+		return strings.TrimSpace(string(v)), true
 	}
-	return "unknown"
+	return "unknown", false
 }
 
-func (n *netIf) readGauge(gname string) gauge {
-	g := gauge{}
-	fname := "/sys/class/net/" + n.name + "/statistics/" + gname
+// readGauge reads the gauge, carrying the known width of the counter over
+// from the previous reading.
+func (n *netIf) readGauge(gname string, prev gauge) gauge {
+	g := gauge{wide: prev.wide}
+	fname := hostPath("/sys/class/net/" + n.name + "/statistics/" + gname)
 	v, err := os.ReadFile(fname)
 	if err == nil {
 		v, err := strconv.ParseUint(strings.TrimSpace(string(v)), 10, 64)
 		if err == nil {
 			g.valid = true
 			g.value = v
+			g.wide = g.wide || v > math.MaxUint32
 		}
 	}
 	return g
 }
 
 func (n *netIf) Close() {
+	if n.unlisten != nil {
+		n.unlisten()
+	}
 	n.linkPoller.Close()
 	n.statsPoller.Close()
+	n.wirelessPoller.Close()
+	n.nl80211.Close()
+	if n.sampler != nil {
+		n.sampler.Close()
+	}
+	if n.totals != nil {
+		n.totalsMu.Lock()
+		if err := n.state.save(n.totals, true); err != nil {
+			n.log.Warn("unable to save totals", "err", err)
+		}
+		n.totalsMu.Unlock()
+	}
 }
 
 func (n *netIf) Sync(ps PubSub) {
 	n.ps = ps
 	n.linkPoller.Sync(ps)
 	n.statsPoller.Sync(ps)
+	n.wirelessPoller.Sync(ps)
 }
 
 var statsGauges = []string{
@@ -251,6 +715,12 @@ var statsGauges = []string{
 	"tx_bytes",
 	"rx_packets",
 	"tx_packets",
+	"rx_errors",
+	"tx_errors",
+	"rx_dropped",
+	"tx_dropped",
+	"collisions",
+	"rx_crc_errors",
 }
 
 // Rate pairs the rate to the underlying gauge
@@ -265,25 +735,106 @@ var statsRates = []Rate{
 	{"tx_throughput", "tx_bytes", 8},
 	{"rx_packet_rate", "rx_packets", 1},
 	{"tx_packet_rate", "tx_packets", 1},
+	{"rx_error_rate", "rx_errors", 1},
+	{"tx_error_rate", "tx_errors", 1},
+	{"rx_drop_rate", "rx_dropped", 1},
+	{"tx_drop_rate", "tx_dropped", 1},
+	{"collision_rate", "collisions", 1},
+	{"rx_crc_error_rate", "rx_crc_errors", 1},
+}
+
+// Total pairs the lifetime total to the underlying gauge
+type Total struct {
+	total string
+	gauge string
+}
+
+var statsTotals = []Total{
+	{"rx_bytes_total", "rx_bytes"},
+	{"tx_bytes_total", "tx_bytes"},
+	{"rx_packets_total", "rx_packets"},
+	{"tx_packets_total", "tx_packets"},
 }
 
 var statsEntities = []string{
 	"rx_bytes",
 	"tx_bytes",
+	"rx_bytes_total",
+	"tx_bytes_total",
 	"rx_throughput",
 	"tx_throughput",
 	"rx_packets",
 	"tx_packets",
+	"rx_packets_total",
+	"tx_packets_total",
 	"rx_packet_rate",
 	"tx_packet_rate",
+	"rx_errors",
+	"tx_errors",
+	"rx_dropped",
+	"tx_dropped",
+	"collisions",
+	"rx_crc_errors",
+	"rx_error_rate",
+	"tx_error_rate",
+	"rx_drop_rate",
+	"tx_drop_rate",
+	"collision_rate",
+	"rx_crc_error_rate",
+	"day_usage",
+	"month_usage",
+	"quota_remaining",
+}
+
+// usageEntities are the entities requiring usage tracking
+var usageEntities = []string{
+	"day_usage",
+	"month_usage",
+	"quota_remaining",
 }
 
 var linkEntities = []string{
 	"operstate",
 	"carrier",
+	"ipv4",
+	"ipv6",
+	"mac",
+	"speed",
+	"duplex",
+	"mtu",
+}
+
+var linkPropNames = map[string]string{
+	"ipv4":   "IPv4 address",
+	"ipv6":   "IPv6 address",
+	"mac":    "MAC address",
+	"speed":  "speed",
+	"duplex": "duplex",
+	"mtu":    "MTU",
+}
+
+var wirelessNames = map[string]string{
+	"ssid":         "SSID",
+	"bssid":        "BSSID",
+	"frequency":    "frequency",
+	"channel":      "channel",
+	"signal":       "signal",
+	"link_quality": "link quality",
+	"noise":        "noise",
+	"tx_bitrate":   "tx bitrate",
+	"rx_bitrate":   "rx bitrate",
+}
+
+var linkPropIcons = map[string]string{
+	"ipv4":   "mdi:ip",
+	"ipv6":   "mdi:ip",
+	"mac":    "mdi:network-outline",
+	"speed":  "mdi:speedometer",
+	"duplex": "mdi:swap-horizontal",
+	"mtu":    "mdi:arrow-expand-horizontal",
 }
 
-func newNetIf(name string, cfg *netIfConfig) *netIf {
+func newNetIf(mod *Module, name string, cfg *netIfConfig) *netIf {
 	// link and stats may inherit period
 	if len(cfg.Link.Period) == 0 {
 		cfg.Link.Period = cfg.Period
@@ -293,57 +844,150 @@ func newNetIf(name string, cfg *netIfConfig) *netIf {
 	}
 	se := map[string]bool{}
 	le := map[string]bool{}
+	we := map[string]bool{}
 	for _, e := range cfg.Entities {
 		if slices.Contains(statsEntities, e) {
 			se[e] = true
 		} else if slices.Contains(linkEntities, e) {
 			le[e] = true
+		} else if slices.Contains(wirelessEntities, e) {
+			we[e] = true
 		}
 	}
 	n := netIf{
 		name:          name,
 		statsEntities: se,
 		linkEntities:  le,
-		online:        getLink(),
 		ps:            StubPubSub{},
 		gauges:        map[string]gauge{},
+		stopic:        mod.StateTopic("/" + name),
+		deadbands:     newDeadbands(cfg.Deadband),
+		agg:           newAggregator(&cfg.Sample),
+		sampleGauges:  map[string]gauge{},
+		log:           mod.log,
+		totalGauges:   map[string]bool{},
 	}
-	if se["rx_bytes"] || se["rx_throughput"] {
-		n.gauges["rx_bytes"] = n.readGauge("rx_bytes")
+	for _, t := range statsTotals {
+		if se[t.total] {
+			n.totalGauges[t.gauge] = true
+		}
+	}
+	if cfg.Usage.CapPercent > 0 || slices.ContainsFunc(usageEntities, func(e string) bool { return se[e] }) {
+		n.usage = newUsage(&cfg.Usage)
+		if se["quota_remaining"] && n.usage.quota == 0 {
+			log.Fatalf("net %s: quota_remaining requires a usage quota", name)
+		}
+		n.totalGauges["rx_bytes"] = true
+		n.totalGauges["tx_bytes"] = true
+		if cfg.Usage.CapPercent > 0 {
+			// the cap is a threshold on the month usage
+			if cfg.Thresholds == nil {
+				cfg.Thresholds = map[string]thresholdConfig{}
+			}
+			limit := float64(n.usage.quota) * cfg.Usage.CapPercent / 100
+			cfg.Thresholds["usage_cap"] = thresholdConfig{Entity: "month_usage", Above: &limit}
+		}
 	}
-	if se["tx_bytes"] || se["tx_throughput"] {
-		n.gauges["tx_bytes"] = n.readGauge("tx_bytes")
+	tentities := slices.Clone(statsEntities)
+	for _, r := range statsRates {
+		tentities = append(tentities, n.agg.statNames(r.rate)...)
 	}
-	if se["rx_packets"] || se["rx_packet_rate"] {
-		n.gauges["rx_packets"] = n.readGauge("rx_packets")
+	n.thresholds = newThresholds(cfg.Thresholds, tentities, name+"_", "/"+name+"/stats", n.stopic+"/stats")
+	// gauges are read if required by any enabled entity
+	for _, gname := range statsGauges {
+		if se[gname] || n.totalGauges[gname] ||
+			slices.ContainsFunc(statsRates, func(r Rate) bool { return r.gauge == gname && se[r.rate] }) {
+			n.gauges[gname] = n.readGauge(gname, gauge{})
+		}
 	}
-	if se["tx_packets"] || se["tx_packet_rate"] {
-		n.gauges["tx_packets"] = n.readGauge("tx_packets")
+	if len(n.totalGauges) > 0 {
+		n.loadTotals(mod.name)
 	}
 	if len(le) > 0 {
 		n.linkPoller = &PolledSensor{
 			topic:  "/" + name,
-			poller: NewPoller(&cfg.Link, n.RefreshLink),
+			poller: mod.NewPoller(&cfg.Link, n.RefreshLink),
+			ps:     StubPubSub{},
+		}
+		n.unlisten = linkEvents.listen(func(ifname string) {
+			if ifname == name || len(ifname) == 0 {
+				n.linkPoller.poller.RefreshAsync()
+			}
+		})
+	}
+	// wireless entities are ignored for other interfaces
+	if len(we) > 0 && isWireless(name) {
+		n.wirelessEntities = we
+		if len(cfg.Wireless.Period) == 0 {
+			cfg.Wireless.Period = cfg.Period
+		}
+		n.wirelessPoller = &PolledSensor{
+			topic:  "/" + name + "/wireless",
+			poller: mod.NewPoller(&cfg.Wireless, n.RefreshWireless),
 			ps:     StubPubSub{},
 		}
 	}
 	if len(se) > 0 {
 		n.statsPoller = &PolledSensor{
 			topic:  "/" + name + "/stats",
-			poller: NewPoller(&cfg.Stats, n.RefreshStats),
+			poller: mod.NewPoller(&cfg.Stats, n.RefreshStats),
 			ps:     StubPubSub{},
 		}
+		if n.agg != nil {
+			n.sampleTime = time.Now()
+			maps.Copy(n.sampleGauges, n.gauges)
+			n.sampler = mod.NewPoller(&pollerConfig{Period: cfg.Sample.Period}, n.sample)
+		}
 	}
 	return &n
 }
 
+// loadTotals loads the persisted lifetime totals for the interface.
+func (n *netIf) loadTotals(modName string) {
+	n.state = newStateFile(modName + "-" + n.name)
+	n.totals = &netTotals{}
+	if err := n.state.load(n.totals); err != nil {
+		n.log.Warn("unable to load totals", "err", err)
+	}
+	if n.totals.Counters == nil {
+		n.totals.Counters = map[string]*counter{}
+	}
+	// counters restart from zero on boot
+	bootID, err := os.ReadFile(hostPath("/proc/sys/kernel/random/boot_id"))
+	if err != nil {
+		n.log.Warn("unable to read boot_id", "err", err)
+	}
+	if id := strings.TrimSpace(string(bootID)); id != n.totals.BootID {
+		n.totals.restart()
+		n.totals.BootID = id
+	}
+}
+
+// sample collects a sample of the enabled rates for aggregation.
+func (n *netIf) sample(_ bool) {
+	t := time.Now()
+	elapsed := t.Sub(n.sampleTime)
+	n.sampleTime = t
+	for _, r := range statsRates {
+		if !n.statsEntities[r.rate] {
+			continue
+		}
+		old := n.sampleGauges[r.gauge]
+		g := n.readGauge(r.gauge, old)
+		n.sampleGauges[r.gauge] = g
+		if old.valid && g.valid && elapsed > 0 {
+			n.agg.add(r.rate, old.rate(g, elapsed)*r.scaling)
+		}
+	}
+}
+
 func (n *netIf) Config() []EntityConfig {
 	var config []EntityConfig
 	if n.linkPoller != nil {
 		if n.linkEntities["operstate"] {
 			cfg := map[string]any{
 				"name":           "net " + n.name,
-				"state_topic":    "~/net/" + n.name,
+				"state_topic":    n.stopic,
 				"value_template": "{{value_json.operstate | is_defined}}",
 				"device_class":   "connectivity",
 				"payload_on":     "up",
@@ -352,12 +996,13 @@ func (n *netIf) Config() []EntityConfig {
 			if strings.HasPrefix(n.name, "wlan") {
 				cfg["icon"] = "mdi:wifi-check"
 			}
+			setFieldAvailability(cfg, n.stopic, "operstate")
 			config = append(config, EntityConfig{n.name + "-operstate", "binary_sensor", cfg})
 		}
 		if n.linkEntities["carrier"] {
 			cfg := map[string]any{
 				"name":           "net " + n.name + " carrier",
-				"state_topic":    "~/net/" + n.name,
+				"state_topic":    n.stopic,
 				"value_template": "{{value_json.carrier | is_defined}}",
 				"device_class":   "connectivity",
 				"payload_on":     "1",
@@ -366,24 +1011,88 @@ func (n *netIf) Config() []EntityConfig {
 			if strings.HasPrefix(n.name, "wlan") {
 				cfg["icon"] = "mdi:wifi"
 			}
+			setFieldAvailability(cfg, n.stopic, "carrier")
 			config = append(config, EntityConfig{n.name + "-carrier", "binary_sensor", cfg})
 		}
+		for _, e := range []string{"ipv4", "ipv6", "mac", "speed", "duplex", "mtu"} {
+			if !n.linkEntities[e] {
+				continue
+			}
+			cfg := map[string]any{
+				"name":            fmt.Sprintf("net %s %s", n.name, linkPropNames[e]),
+				"state_topic":     n.stopic,
+				"value_template":  fmt.Sprintf("{{value_json.%s | is_defined}}", e),
+				"icon":            linkPropIcons[e],
+				"entity_category": "diagnostic",
+			}
+			if e == "speed" {
+				cfg["unit_of_measurement"] = "Mbit/s"
+				cfg["device_class"] = "data_rate"
+			}
+			setFieldAvailability(cfg, n.stopic, e)
+			config = append(config, EntityConfig{n.name + "-" + e, "sensor", cfg})
+		}
+	}
+	for _, e := range wirelessEntities {
+		if !n.wirelessEntities[e] {
+			continue
+		}
+		topic := n.stopic + "/wireless"
+		cfg := map[string]any{
+			"name":           fmt.Sprintf("net %s %s", n.name, wirelessNames[e]),
+			"state_topic":    topic,
+			"value_template": fmt.Sprintf("{{value_json.%s | is_defined}}", e),
+		}
+		switch e {
+		case "ssid":
+			cfg["icon"] = "mdi:wifi"
+		case "bssid", "frequency", "channel":
+			cfg["icon"] = "mdi:access-point"
+			cfg["entity_category"] = "diagnostic"
+		case "link_quality":
+			cfg["icon"] = "mdi:wifi-strength-3"
+			cfg["unit_of_measurement"] = "%"
+			cfg["state_class"] = "measurement"
+		case "signal", "noise":
+			cfg["unit_of_measurement"] = "dBm"
+			cfg["device_class"] = "signal_strength"
+			cfg["state_class"] = "measurement"
+		case "tx_bitrate", "rx_bitrate":
+			cfg["unit_of_measurement"] = "Mbit/s"
+			cfg["device_class"] = "data_rate"
+			cfg["state_class"] = "measurement"
+		}
+		if e == "frequency" {
+			cfg["unit_of_measurement"] = "MHz"
+			cfg["device_class"] = "frequency"
+		}
+		setFieldAvailability(cfg, topic, e)
+		config = append(config, EntityConfig{n.name + "-" + e, "sensor", cfg})
 	}
 	for e := range n.statsEntities {
 		cfg := map[string]any{
 			"name": fmt.Sprintf("net %s %s", n.name,
 				strings.ReplaceAll(e, "_", " ")),
-			"state_topic":    fmt.Sprintf("~/net/%s/stats", n.name),
+			"state_topic":    n.stopic + "/stats",
 			"value_template": fmt.Sprintf("{{value_json.%s | is_defined}}", e),
 		}
-		if strings.HasSuffix(e, "_bytes") {
+		if strings.HasSuffix(e, "_total") || strings.HasSuffix(e, "_usage") {
+			cfg["state_class"] = "total_increasing"
+		}
+		if strings.HasSuffix(e, "_bytes") || strings.HasSuffix(e, "_bytes_total") ||
+			slices.Contains(usageEntities, e) {
 			cfg["unit_of_measurement"] = "bytes"
+			if slices.Contains(usageEntities, e) {
+				cfg["icon"] = "mdi:counter"
+			}
 		} else if strings.HasSuffix(e, "_throughput") {
 			cfg["unit_of_measurement"] = "bps"
-		} else if strings.HasSuffix(e, "_packets") {
+		} else if strings.HasSuffix(e, "_packets") || strings.HasSuffix(e, "_packets_total") {
 			cfg["unit_of_measurement"] = "pkts"
 		} else if strings.HasSuffix(e, "_packet_rate") {
 			cfg["unit_of_measurement"] = "pps"
+		} else if strings.HasSuffix(e, "_rate") {
+			cfg["unit_of_measurement"] = "/s"
 		}
 
 		if strings.HasPrefix(n.name, "wlan") {
@@ -399,8 +1108,22 @@ func (n *netIf) Config() []EntityConfig {
 				cfg["icon"] = "mdi:download-network-outline"
 			}
 		}
+		// failures override the direction icons
+		if strings.Contains(e, "error") {
+			cfg["icon"] = "mdi:alert-circle-outline"
+		} else if strings.Contains(e, "drop") {
+			cfg["icon"] = "mdi:package-variant-remove"
+		} else if strings.HasPrefix(e, "collision") {
+			cfg["icon"] = "mdi:car-brake-alert"
+		}
 
-		config = append(config, EntityConfig{n.name + "-" + e, "sensor", cfg})
+		setFieldAvailability(cfg, n.stopic+"/stats", e)
+		ec := EntityConfig{n.name + "-" + e, "sensor", cfg}
+		config = append(config, ec)
+		if slices.ContainsFunc(statsRates, func(r Rate) bool { return r.rate == e }) {
+			config = append(config, n.agg.entityConfigs(ec, n.stopic+"/stats", e)...)
+		}
 	}
+	config = append(config, n.thresholds.Config()...)
 	return config
 }
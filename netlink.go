@@ -0,0 +1,356 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// rtnetlink multicast groups, from linux/rtnetlink.h
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6IfAddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// linkMonitor distributes rtnetlink notifications of changes to network
+// links, addresses and default routes, so link state can be refreshed
+// immediately rather than waiting for the next poll.
+//
+// If netlink is unavailable there are no notifications and the link
+// sensors fall back to polling alone.
+type linkMonitor struct {
+	mu             sync.Mutex
+	started        bool
+	id             int
+	listeners      map[int]func(ifname string)
+	routeListeners map[int]func()
+}
+
+var linkEvents = linkMonitor{
+	listeners:      map[int]func(string){},
+	routeListeners: map[int]func(){},
+}
+
+// listen adds a listener for link changes, returning a function to remove it.
+//
+// The listener is passed the name of the changed interface, or an empty name
+// if the interface is unknown, such as when notifications have been lost.
+// Listeners are called from the monitor goroutine, so must not block.
+func (m *linkMonitor) listen(f func(ifname string)) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.started {
+		m.started = true
+		m.start()
+	}
+	m.id++
+	id := m.id
+	m.listeners[id] = f
+	return func() {
+		m.mu.Lock()
+		delete(m.listeners, id)
+		m.mu.Unlock()
+	}
+}
+
+// listenRoutes adds a listener for changes to the default routes, returning
+// a function to remove it.
+//
+// Listeners are called from the monitor goroutine, so must not block.
+func (m *linkMonitor) listenRoutes(f func()) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.started {
+		m.started = true
+		m.start()
+	}
+	m.id++
+	id := m.id
+	m.routeListeners[id] = f
+	return func() {
+		m.mu.Lock()
+		delete(m.routeListeners, id)
+		m.mu.Unlock()
+	}
+}
+
+func (m *linkMonitor) start() {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		slog.Warn("netlink unavailable - link state will be polled", "err", err)
+		return
+	}
+	sa := syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr | rtmgrpIPv4Route | rtmgrpIPv6Route,
+	}
+	if err = syscall.Bind(fd, &sa); err != nil {
+		syscall.Close(fd)
+		slog.Warn("netlink unavailable - link state will be polled", "err", err)
+		return
+	}
+	go m.run(fd)
+}
+
+func (m *linkMonitor) run(fd int) {
+	defer syscall.Close(fd)
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.ENOBUFS {
+			// notifications were dropped, so anything may have changed
+			m.notify("")
+			m.notifyRoute()
+			continue
+		}
+		if err != nil {
+			slog.Warn("netlink receive failed - link state will be polled", "err", err)
+			return
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			slog.Warn("error parsing netlink message", "err", err)
+			continue
+		}
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case syscall.RTM_NEWLINK, syscall.RTM_DELLINK,
+				syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+				m.notify(msgIfName(&msg))
+			case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+				// rtmsg has the destination prefix length at offset 1
+				if len(msg.Data) > 1 && msg.Data[1] == 0 {
+					m.notifyRoute()
+				}
+			}
+		}
+	}
+}
+
+func (m *linkMonitor) notify(ifname string) {
+	m.mu.Lock()
+	ll := slices.Collect(maps.Values(m.listeners))
+	m.mu.Unlock()
+	for _, f := range ll {
+		f(ifname)
+	}
+}
+
+func (m *linkMonitor) notifyRoute() {
+	m.mu.Lock()
+	ll := slices.Collect(maps.Values(m.routeListeners))
+	m.mu.Unlock()
+	for _, f := range ll {
+		f()
+	}
+}
+
+// msgIfName returns the name of the interface a link or address message
+// refers to, or an empty string if it cannot be determined.
+func msgIfName(msg *syscall.NetlinkMessage) string {
+	if msg.Header.Type == syscall.RTM_NEWLINK || msg.Header.Type == syscall.RTM_DELLINK {
+		// the name is included in link messages, even for deleted links
+		attrs, err := syscall.ParseNetlinkRouteAttr(msg)
+		if err == nil {
+			for _, a := range attrs {
+				if a.Attr.Type == syscall.IFLA_IFNAME {
+					return strings.TrimRight(string(a.Value), "\x00")
+				}
+			}
+		}
+	}
+	// both ifinfomsg and ifaddrmsg have the interface index at offset 4
+	if len(msg.Data) < 8 {
+		return ""
+	}
+	idx := binary.NativeEndian.Uint32(msg.Data[4:8])
+	iface, err := net.InterfaceByIndex(int(idx))
+	if err != nil {
+		return ""
+	}
+	return iface.Name
+}
+
+// nlConn is a netlink socket for request/response exchanges, such as
+// generic netlink queries.
+type nlConn struct {
+	fd  int
+	seq uint32
+	// the socket has failed, as distinct from a request being rejected, so
+	// the connection should be replaced
+	failed bool
+}
+
+func dialNetlink(proto int) (*nlConn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// don't hang a poller if the kernel never responds
+	tv := syscall.Timeval{Sec: 2}
+	if err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &nlConn{fd: fd}, nil
+}
+
+func (c *nlConn) Close() {
+	syscall.Close(c.fd)
+}
+
+// request sends a request and returns the payloads of the responses.
+//
+// Dump requests may return multiple responses.
+func (c *nlConn) request(typ, flags uint16, data []byte) ([][]byte, error) {
+	c.seq++
+	b := make([]byte, syscall.NLMSG_HDRLEN, syscall.NLMSG_HDRLEN+len(data))
+	binary.NativeEndian.PutUint32(b[0:4], uint32(syscall.NLMSG_HDRLEN+len(data)))
+	binary.NativeEndian.PutUint16(b[4:6], typ)
+	binary.NativeEndian.PutUint16(b[6:8], flags|syscall.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(b[8:12], c.seq)
+	b = append(b, data...)
+	if err := syscall.Sendto(c.fd, b, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		c.failed = true
+		return nil, err
+	}
+	var resp [][]byte
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := syscall.Recvfrom(c.fd, buf, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			c.failed = true
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			c.failed = true
+			return nil, err
+		}
+		multi := false
+		for _, msg := range msgs {
+			if msg.Header.Seq != c.seq {
+				continue
+			}
+			switch msg.Header.Type {
+			case syscall.NLMSG_DONE:
+				return resp, nil
+			case syscall.NLMSG_ERROR:
+				if len(msg.Data) < 4 {
+					return nil, syscall.EINVAL
+				}
+				if errno := int32(binary.NativeEndian.Uint32(msg.Data[0:4])); errno != 0 {
+					return nil, syscall.Errno(-errno)
+				}
+				return resp, nil
+			}
+			resp = append(resp, slices.Clone(msg.Data))
+			multi = msg.Header.Flags&syscall.NLM_F_MULTI != 0
+		}
+		if !multi && len(resp) > 0 {
+			return resp, nil
+		}
+	}
+}
+
+// generic netlink controller, from linux/genetlink.h
+const (
+	genlIDCtrl          = 0x10
+	genlCtrlGetFamily   = 3
+	genlCtrlAttrFamID   = 1
+	genlCtrlAttrFamName = 2
+	genlHdrLen          = 4
+	// strips the nested and byte order flags from attribute types
+	nlaTypeMask = 0x3fff
+)
+
+// genlFamily returns the ID of a generic netlink family.
+func (c *nlConn) genlFamily(name string) (uint16, error) {
+	resp, err := c.genlRequest(genlIDCtrl, genlCtrlGetFamily, 0,
+		nlAttr(genlCtrlAttrFamName, append([]byte(name), 0)))
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range resp {
+		if id, ok := parseNlAttrs(r)[genlCtrlAttrFamID]; ok && len(id) >= 2 {
+			return binary.NativeEndian.Uint16(id), nil
+		}
+	}
+	return 0, syscall.ENOENT
+}
+
+// genlRequest sends a generic netlink command and returns the attributes
+// of the responses.
+func (c *nlConn) genlRequest(family uint16, cmd uint8, flags uint16, attrs ...[]byte) ([][]byte, error) {
+	data := []byte{cmd, 1, 0, 0}
+	for _, a := range attrs {
+		data = append(data, a...)
+	}
+	resp, err := c.request(family, flags, data)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range resp {
+		if len(r) < genlHdrLen {
+			return nil, syscall.EINVAL
+		}
+		resp[i] = r[genlHdrLen:]
+	}
+	return resp, nil
+}
+
+// nlAttr encodes a netlink attribute.
+func nlAttr(typ uint16, value []byte) []byte {
+	l := syscall.SizeofRtAttr + len(value)
+	b := make([]byte, 4, nlAlign(l))
+	binary.NativeEndian.PutUint16(b[0:2], uint16(l))
+	binary.NativeEndian.PutUint16(b[2:4], typ)
+	b = append(b, value...)
+	return b[:cap(b)]
+}
+
+// nlAttrU32 encodes a u32 netlink attribute.
+func nlAttrU32(typ uint16, v uint32) []byte {
+	return nlAttr(typ, binary.NativeEndian.AppendUint32(nil, v))
+}
+
+func nlAlign(l int) int {
+	return (l + syscall.NLA_ALIGNTO - 1) &^ (syscall.NLA_ALIGNTO - 1)
+}
+
+// parseNlAttrs parses a sequence of netlink attributes, keyed by type.
+func parseNlAttrs(b []byte) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	for len(b) >= syscall.SizeofRtAttr {
+		l := int(binary.NativeEndian.Uint16(b[0:2]))
+		typ := binary.NativeEndian.Uint16(b[2:4]) & nlaTypeMask
+		if l < syscall.SizeofRtAttr || l > len(b) {
+			break
+		}
+		attrs[typ] = b[syscall.SizeofRtAttr:l]
+		b = b[min(nlAlign(l), len(b)):]
+	}
+	return attrs
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// the captured dumps are from a little endian host
+func requireLittleEndian(t *testing.T) {
+	t.Helper()
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("captured dumps are little endian")
+	}
+}
+
+// an nl80211 station dump for an AP, after the genl header, containing the
+// ifindex, mac, generation and station info.
+var stationDump = []byte{
+	0x08, 0x00, 0x03, 0x00, 0x03, 0x00, 0x00, 0x00,
+	0x0a, 0x00, 0x06, 0x00, 0x02, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x00,
+	0x08, 0x00, 0x2e, 0x00, 0x2a, 0x00, 0x00, 0x00,
+	0x58, 0x00, 0x15, 0x80,
+	// inactive time
+	0x08, 0x00, 0x01, 0x00, 0xdc, 0x05, 0x00, 0x00,
+	// rx bytes
+	0x08, 0x00, 0x02, 0x00, 0x40, 0xe2, 0x01, 0x00,
+	// rx bytes 64
+	0x0c, 0x00, 0x17, 0x00, 0x00, 0xf2, 0x05, 0x2a, 0x01, 0x00, 0x00, 0x00,
+	// signal
+	0x05, 0x00, 0x07, 0x00, 0xcc, 0x00, 0x00, 0x00,
+	// tx bitrate
+	0x28, 0x00, 0x08, 0x80,
+	0x08, 0x00, 0x05, 0x00, 0xdb, 0x21, 0x00, 0x00,
+	0x06, 0x00, 0x01, 0x00, 0xdb, 0x21, 0x00, 0x00,
+	0x05, 0x00, 0x06, 0x00, 0x09, 0x00, 0x00, 0x00,
+	0x05, 0x00, 0x07, 0x00, 0x02, 0x00, 0x00, 0x00,
+	0x04, 0x00, 0x08, 0x00,
+	// connected time
+	0x08, 0x00, 0x10, 0x00, 0x10, 0x0e, 0x00, 0x00,
+}
+
+func TestParseNlAttrs(t *testing.T) {
+	requireLittleEndian(t)
+	patterns := []struct {
+		name  string
+		b     []byte
+		attrs map[uint16][]byte
+	}{
+		{"empty", nil, map[uint16][]byte{}},
+		{"u32", []byte{0x08, 0x00, 0x03, 0x00, 0x03, 0x00, 0x00, 0x00},
+			map[uint16][]byte{3: {0x03, 0x00, 0x00, 0x00}}},
+		{"padded", []byte{0x05, 0x00, 0x07, 0x00, 0xcc, 0x00, 0x00, 0x00, 0x04, 0x00, 0x08, 0x00},
+			map[uint16][]byte{7: {0xcc}, 8: {}}},
+		{"unpadded tail", []byte{0x04, 0x00, 0x08, 0x00, 0x05, 0x00, 0x07, 0x00, 0xcc},
+			map[uint16][]byte{7: {0xcc}, 8: {}}},
+		{"nested flag", []byte{0x08, 0x00, 0x15, 0x80, 0x04, 0x00, 0x08, 0x00},
+			map[uint16][]byte{21: {0x04, 0x00, 0x08, 0x00}}},
+		{"truncated", []byte{0x04, 0x00, 0x08, 0x00, 0x08, 0x00, 0x03, 0x00, 0x03},
+			map[uint16][]byte{8: {}}},
+		{"bad length", []byte{0x02, 0x00, 0x08, 0x00, 0x04, 0x00, 0x09, 0x00},
+			map[uint16][]byte{}},
+		{"short header", []byte{0x04, 0x00}, map[uint16][]byte{}},
+	}
+	for _, p := range patterns {
+		t.Run(p.name, func(t *testing.T) {
+			attrs := parseNlAttrs(p.b)
+			if !maps.EqualFunc(attrs, p.attrs, bytes.Equal) {
+				t.Errorf("got %v, want %v", attrs, p.attrs)
+			}
+		})
+	}
+}
+
+func TestParseNlAttrsStationDump(t *testing.T) {
+	requireLittleEndian(t)
+	attrs := parseNlAttrs(stationDump)
+	keys := slices.Sorted(maps.Keys(attrs))
+	if want := []uint16{3, 6, 21, 46}; !slices.Equal(keys, want) {
+		t.Fatalf("attrs: got %v, want %v", keys, want)
+	}
+	if !bytes.Equal(attrs[nl80211AttrMac], []byte{0x02, 0x11, 0x22, 0x33, 0x44, 0x55}) {
+		t.Errorf("mac: got %x", attrs[nl80211AttrMac])
+	}
+	info := parseNlAttrs(attrs[nl80211AttrStaInfo])
+	keys = slices.Sorted(maps.Keys(info))
+	if want := []uint16{1, 2, 7, 8, 16, 23}; !slices.Equal(keys, want) {
+		t.Fatalf("station info: got %v, want %v", keys, want)
+	}
+	if signal := int8(info[nl80211StaInfoSignal][0]); signal != -52 {
+		t.Errorf("signal: got %d, want -52", signal)
+	}
+	if rx := staBytes(info, nl80211StaInfoRxBytes64, nl80211StaInfoRxBytes); rx != 5000000000 {
+		t.Errorf("rx bytes: got %d, want 5000000000", rx)
+	}
+	if tx := staBytes(info, nl80211StaInfoTxBytes64, nl80211StaInfoTxBytes); tx != 0 {
+		t.Errorf("tx bytes: got %d, want 0", tx)
+	}
+	if br := bitrate(info[nl80211StaInfoTxBitrate]); br != 866.7 {
+		t.Errorf("tx bitrate: got %v, want 866.7", br)
+	}
+}
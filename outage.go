@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the windows the link availability is reported over
+var availabilityWindows = []struct {
+	field  string
+	period time.Duration
+}{
+	{"availability_24h", 24 * time.Hour},
+	{"availability_7d", 7 * 24 * time.Hour},
+}
+
+// the minimum period between warnings of failures to save the history
+const outageSaveWarnPeriod = time.Hour
+
+// outage is a period the link was down, or was not observed.
+type outage struct {
+	Start time.Time `json:"start"`
+	// zero while the outage is ongoing
+	End time.Time `json:"end"`
+}
+
+// overlap returns the duration of the period within the window from start
+// to now.
+func (o outage) overlap(start, now time.Time) time.Duration {
+	end := o.End
+	if end.IsZero() || end.After(now) {
+		end = now
+	}
+	from := o.Start
+	if from.Before(start) {
+		from = start
+	}
+	if end.After(from) {
+		return end.Sub(from)
+	}
+	return 0
+}
+
+// outageHistory is the persistent record of link outages.
+type outageHistory struct {
+	Count int `json:"count"`
+	// the start of the current uptime, zero while the link is down
+	UpSince time.Time `json:"up_since"`
+	// the time tracking began, which bounds the availability windows
+	Since time.Time `json:"since"`
+	// the outages overlapping the longest availability window, oldest first
+	Outages []outage `json:"outages"`
+	// the time the link was last observed
+	Observed time.Time `json:"observed"`
+	// the periods the link was not observed, as dunnart was not running,
+	// overlapping the longest availability window, oldest first
+	Unobserved []outage `json:"unobserved"`
+}
+
+// resume ends any outage in progress when dunnart stopped at the time the
+// link was last observed, and records the time since as unobserved, as the
+// state of the link while dunnart was not running is unknown.
+//
+// The up since time is retained, so it continues from the last outage if the
+// link is up when next observed.
+func (h *outageHistory) resume(now time.Time) {
+	if h.Observed.IsZero() {
+		return
+	}
+	if n := len(h.Outages); n > 0 && h.Outages[n-1].End.IsZero() {
+		h.Outages[n-1].End = h.Observed
+	}
+	if now.After(h.Observed) {
+		h.Unobserved = append(h.Unobserved, outage{Start: h.Observed, End: now})
+	}
+}
+
+// outages tracks the outage history of the link.
+type outages struct {
+	mu      sync.Mutex
+	state   *stateFile
+	history outageHistory
+	log     *slog.Logger
+	// the time a failure to save the history was last warned of
+	warned time.Time
+}
+
+func newOutages(name string, logger *slog.Logger) *outages {
+	o := outages{state: newStateFile(name), log: logger}
+	if err := o.state.load(&o.history); err != nil {
+		logger.Warn("unable to load outage history", "err", err)
+		o.history = outageHistory{}
+	}
+	o.history.resume(time.Now())
+	return &o
+}
+
+// update records the link state observed at the time.
+//
+// Failures to save the history are warned of at most once every
+// outageSaveWarnPeriod, as they are retried every update.
+func (o *outages) update(now time.Time, online bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h := &o.history
+	if h.Since.IsZero() {
+		h.Since = now
+	}
+	h.Observed = now
+	changed := false
+	ongoing := len(h.Outages) > 0 && h.Outages[len(h.Outages)-1].End.IsZero()
+	if online {
+		if ongoing {
+			h.Outages[len(h.Outages)-1].End = now
+			changed = true
+		}
+		if h.UpSince.IsZero() {
+			h.UpSince = now
+			changed = true
+		}
+	} else if !ongoing {
+		h.Count++
+		h.Outages = append(h.Outages, outage{Start: now})
+		h.UpSince = time.Time{}
+		changed = true
+	}
+	// drop periods that ended before the longest window
+	horizon := now.Add(-availabilityWindows[len(availabilityWindows)-1].period)
+	for len(h.Outages) > 1 && !h.Outages[0].End.IsZero() && h.Outages[0].End.Before(horizon) {
+		h.Outages = h.Outages[1:]
+	}
+	for len(h.Unobserved) > 0 && h.Unobserved[0].End.Before(horizon) {
+		h.Unobserved = h.Unobserved[1:]
+	}
+	if err := o.state.save(h, changed); err != nil {
+		if now.Sub(o.warned) >= outageSaveWarnPeriod {
+			o.log.Warn("unable to save outage history", "err", err)
+			o.warned = now
+		} else {
+			o.log.Debug("unable to save outage history", "err", err)
+		}
+	}
+}
+
+// save forces the history to be saved.
+func (o *outages) save() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.state.save(&o.history, true)
+}
+
+// msg returns the state message for the outage sensors at the time.
+//
+// Durations are in seconds, and availabilities are percentages of the time
+// observed within each window, so exclude periods dunnart was not running.
+func (o *outages) msg(now time.Time) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h := &o.history
+	fields := []string{fmt.Sprintf(`"count": %d`, h.Count)}
+	if n := len(h.Outages); n > 0 {
+		last := h.Outages[n-1]
+		fields = append(fields, fmt.Sprintf(`"last_start": "%s"`, last.Start.UTC().Format(time.RFC3339)))
+		if !last.End.IsZero() {
+			fields = append(fields, fmt.Sprintf(`"last_duration": %d`, int64(last.End.Sub(last.Start).Seconds())))
+		}
+	}
+	if !h.UpSince.IsZero() {
+		fields = append(fields, fmt.Sprintf(`"up_since": "%s"`, h.UpSince.UTC().Format(time.RFC3339)))
+	}
+	for _, w := range availabilityWindows {
+		start := now.Add(-w.period)
+		if h.Since.After(start) {
+			start = h.Since
+		}
+		observed := now.Sub(start)
+		for _, u := range h.Unobserved {
+			observed -= u.overlap(start, now)
+		}
+		if observed <= 0 {
+			continue
+		}
+		down := time.Duration(0)
+		for _, ot := range h.Outages {
+			down += ot.overlap(start, now)
+		}
+		avail := 100 * (1 - float64(down)/float64(observed))
+		fields = append(fields, fmt.Sprintf(`"%s": %.3f`, w.field, avail))
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
@@ -6,6 +6,8 @@ package main
 
 import (
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,8 +17,19 @@ type Poller struct {
 	refresh chan bool
 	done    chan struct{}
 	t       *time.Ticker
+	// unix nano time the current call of the polled function started,
+	// or 0 if idle.
+	busy atomic.Int64
+	// set while an asynchronous refresh is waiting to be accepted.
+	pending atomic.Bool
 }
 
+// the set of active pollers, for liveness checks
+var (
+	pollersMu sync.Mutex
+	pollers   = map[*Poller]struct{}{}
+)
+
 type pollerConfig struct {
 	Period string
 }
@@ -33,11 +46,16 @@ func NewPoller(cfg *pollerConfig, f func(bool)) *Poller {
 		refresh: make(chan bool),
 		done:    make(chan struct{}),
 	}
+	pollersMu.Lock()
+	pollers[&p] = struct{}{}
+	pollersMu.Unlock()
 	go func() {
 		for {
 			select {
 			case forced := <-p.refresh:
+				p.busy.Store(time.Now().UnixNano())
 				f(forced)
+				p.busy.Store(0)
 			case <-p.done:
 				return
 			}
@@ -71,6 +89,20 @@ func (p *Poller) Refresh(forced bool) {
 	}
 }
 
+// RefreshAsync triggers an unforced call of the polled function, without
+// waiting for the poller to accept it.
+//
+// Triggers made while a refresh is pending are coalesced into it.
+func (p *Poller) RefreshAsync() {
+	if !p.pending.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		p.Refresh(false)
+		p.pending.Store(false)
+	}()
+}
+
 // UpdatePeriod sets the update period for the Poller.
 // Triggers an immediate unforced updated of the polled function
 // before beginning the new update period.
@@ -86,9 +118,26 @@ func (p *Poller) UpdatePeriod(period time.Duration) {
 
 // Close shuts down the Poller goroutines.
 func (p *Poller) Close() {
+	pollersMu.Lock()
+	delete(pollers, p)
+	pollersMu.Unlock()
 	close(p.done)
 }
 
+// pollersAlive returns false if any poller has been stuck in its polled
+// function for longer than the timeout.
+func pollersAlive(timeout time.Duration) bool {
+	deadline := time.Now().Add(-timeout).UnixNano()
+	pollersMu.Lock()
+	defer pollersMu.Unlock()
+	for p := range pollers {
+		if busy := p.busy.Load(); busy != 0 && busy < deadline {
+			return false
+		}
+	}
+	return true
+}
+
 // PolledSensor represents a sensor which is regularly polled.
 type PolledSensor struct {
 	topic  string
@@ -0,0 +1,391 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterModule("presence", newPresence)
+}
+
+type presenceConfig struct {
+	pollerConfig `yaml:",inline"`
+	// The time since a device was last seen before it is considered away.
+	ConsiderAway string `yaml:"consider_away"`
+	// The DHCP lease files to read, in dnsmasq or odhcpd format.
+	Leases []string
+	// The devices to track, keyed by entity name.
+	Devices map[string]presenceDeviceConfig
+}
+
+type presenceDeviceConfig struct {
+	// The display name of the device.
+	// Defaults to the entity name.
+	Name string
+	MAC  string
+}
+
+// presence tracks the presence of devices on the LAN, as seen in the
+// neighbour table, and the number of active DHCP leases.
+type presence struct {
+	PolledSensor
+	considerAway time.Duration
+	leaseFiles   []string
+	devices      []presenceDevice
+	msg          string
+	stopic       string
+	log          *slog.Logger
+}
+
+type presenceDevice struct {
+	entity   string
+	name     string
+	mac      string
+	lastSeen time.Time
+	msg      string
+}
+
+func newPresence(mod *Module, yamlCfg *yaml.Node) SyncCloser {
+	cfg := presenceConfig{
+		pollerConfig: pollerConfig{Period: "30s"},
+		ConsiderAway: "3m",
+		Leases: []string{
+			"/tmp/dhcp.leases",
+			"/tmp/hosts/odhcpd",
+			"/var/lib/misc/dnsmasq.leases",
+		},
+	}
+	err := yamlCfg.Decode(&cfg)
+	if err != nil {
+		log.Fatalf("error reading presence config: %v", err)
+	}
+	considerAway, err := time.ParseDuration(cfg.ConsiderAway)
+	if err != nil {
+		log.Fatalf("error parsing presence consider_away '%s': %v", cfg.ConsiderAway, err)
+	}
+	p := presence{
+		considerAway: considerAway,
+		leaseFiles:   cfg.Leases,
+		stopic:       mod.StateTopic(""),
+		log:          mod.log,
+	}
+	for entity, dcfg := range cfg.Devices {
+		mac, err := net.ParseMAC(dcfg.MAC)
+		if err != nil {
+			log.Fatalf("error parsing presence device %s mac '%s': %v", entity, dcfg.MAC, err)
+		}
+		name := dcfg.Name
+		if len(name) == 0 {
+			name = entity
+		}
+		p.devices = append(p.devices, presenceDevice{entity: entity, name: name, mac: mac.String()})
+	}
+	slices.SortFunc(p.devices, func(a, b presenceDevice) int {
+		return strings.Compare(a.entity, b.entity)
+	})
+	p.poller = mod.NewPoller(&cfg.pollerConfig, p.Refresh)
+	return &p
+}
+
+func (p *presence) Config() []EntityConfig {
+	cfg := map[string]any{
+		"name":                "Active leases",
+		"state_topic":         p.stopic,
+		"value_template":      "{{value_json.leases | is_defined}}",
+		"unit_of_measurement": "leases",
+		"state_class":         "measurement",
+		"icon":                "mdi:lan-connect",
+	}
+	setFieldAvailability(cfg, p.stopic, "leases")
+	config := []EntityConfig{{"leases", "sensor", cfg}}
+	for _, d := range p.devices {
+		topic := p.stopic + "/" + d.entity
+		cfg := map[string]any{
+			"name":                     d.name,
+			"state_topic":              topic,
+			"value_template":           "{{value_json.state}}",
+			"payload_home":             "home",
+			"payload_not_home":         "not_home",
+			"source_type":              "router",
+			"json_attributes_topic":    topic,
+			"json_attributes_template": "{{value_json.attributes | tojson}}",
+		}
+		config = append(config, EntityConfig{d.entity, "device_tracker", cfg})
+	}
+	return config
+}
+
+func (p *presence) Publish() {
+	p.ps.Publish(p.topic, p.msg)
+	for _, d := range p.devices {
+		if len(d.msg) > 0 {
+			p.ps.Publish("/"+d.entity, d.msg)
+		}
+	}
+}
+
+func (p *presence) Refresh(forced bool) {
+	now := time.Now()
+	neighs, err := readNeighbours()
+	if err != nil {
+		p.log.Debug("neighbour table unavailable", "err", err)
+	}
+	leases, leasesOk := readLeases(p.leaseFiles, now)
+	msg := "{}"
+	if leasesOk {
+		msg = fmt.Sprintf(`{"leases": %d}`, len(leases))
+	}
+	if forced || msg != p.msg {
+		p.msg = msg
+		p.ps.Publish(p.topic, msg)
+	}
+	for i := range p.devices {
+		d := &p.devices[i]
+		n, seen := neighs[d.mac]
+		if seen {
+			d.lastSeen = now
+		}
+		state := "not_home"
+		if !d.lastSeen.IsZero() && now.Sub(d.lastSeen) < p.considerAway {
+			state = "home"
+		}
+		attrs := []string{fmt.Sprintf(`"mac": "%s"`, d.mac)}
+		ip := n.ip
+		l, hasLease := leases[d.mac]
+		if len(ip) == 0 && hasLease {
+			ip = l.ip
+		}
+		if len(ip) > 0 {
+			attrs = append(attrs, fmt.Sprintf(`"ip": "%s"`, ip))
+		}
+		if hasLease && len(l.hostname) > 0 {
+			attrs = append(attrs, fmt.Sprintf(`"host_name": %s`, strconv.Quote(l.hostname)))
+		}
+		if !d.lastSeen.IsZero() {
+			attrs = append(attrs, fmt.Sprintf(`"last_seen": "%s"`, d.lastSeen.Format(time.RFC3339)))
+		}
+		msg := fmt.Sprintf(`{"state": "%s", "attributes": {%s}}`, state, strings.Join(attrs, ", "))
+		if forced || msg != d.msg {
+			d.msg = msg
+			p.ps.Publish("/"+d.entity, msg)
+		}
+	}
+}
+
+// neighbour is a reachable entry in the neighbour table.
+type neighbour struct {
+	ip string
+}
+
+// neighbour table constants, from linux/neighbour.h
+const (
+	ndaDst    = 1
+	ndaLLAddr = 2
+
+	nudReachable = 0x02
+	nudDelay     = 0x08
+	nudProbe     = 0x10
+
+	sizeofNdMsg = 12
+)
+
+// readNeighbours returns the neighbours that have recently been confirmed
+// reachable, keyed by MAC.
+//
+// Stale neighbours are ignored, as stale entries are only removed by garbage
+// collection, which may not occur on small networks, so a device that has
+// left would never be away.  So devices are only seen while they exchange
+// traffic with the host.
+//
+// IPv4 addresses are preferred over IPv6 where a neighbour has both.
+// Falls back to /proc/net/arp, which only contains IPv4 neighbours and
+// does not distinguish stale entries, if netlink is unavailable.
+func readNeighbours() (map[string]neighbour, error) {
+	c, err := dialNetlink(syscall.NETLINK_ROUTE)
+	if err != nil {
+		return readARP()
+	}
+	defer c.Close()
+	resp, err := c.request(syscall.RTM_GETNEIGH, syscall.NLM_F_DUMP, make([]byte, sizeofNdMsg))
+	if err != nil {
+		return readARP()
+	}
+	nn := map[string]neighbour{}
+	for _, r := range resp {
+		if len(r) < sizeofNdMsg {
+			continue
+		}
+		state := binary.NativeEndian.Uint16(r[8:10])
+		if state&(nudReachable|nudDelay|nudProbe) == 0 {
+			continue
+		}
+		attrs := parseNlAttrs(r[sizeofNdMsg:])
+		lladdr, ok := attrs[ndaLLAddr]
+		if !ok || len(lladdr) != 6 {
+			continue
+		}
+		mac := net.HardwareAddr(lladdr).String()
+		ip := net.IP(attrs[ndaDst])
+		if len(ip) == 0 || ip.IsLinkLocalUnicast() {
+			// still seen, just not at a useful address
+			if _, ok := nn[mac]; !ok {
+				nn[mac] = neighbour{}
+			}
+			continue
+		}
+		if n, ok := nn[mac]; ok && len(n.ip) > 0 && (ip.To4() == nil || strings.Contains(n.ip, ".")) {
+			continue
+		}
+		nn[mac] = neighbour{ip: ip.String()}
+	}
+	return nn, nil
+}
+
+// readARP returns the complete entries in the ARP table, keyed by MAC.
+func readARP() (map[string]neighbour, error) {
+	f, err := os.Open(hostPath("/proc/net/arp"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	nn := map[string]neighbour{}
+	scanner := bufio.NewScanner(f)
+	// skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[2], 0, 32)
+		if err != nil || flags&0x2 == 0 {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil {
+			continue
+		}
+		nn[mac.String()] = neighbour{ip: fields[0]}
+	}
+	return nn, nil
+}
+
+// lease is an active DHCP lease.
+type lease struct {
+	ip       string
+	hostname string
+}
+
+// readLeases returns the active DHCPv4 leases in the lease files, keyed by
+// MAC.
+//
+// Returns false if none of the lease files could be read.
+func readLeases(files []string, now time.Time) (map[string]lease, bool) {
+	leases := map[string]lease{}
+	found := false
+	for _, file := range files {
+		f, err := os.Open(hostPath(file))
+		if err != nil {
+			continue
+		}
+		found = true
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var mac string
+			var l lease
+			var ok bool
+			if rest, isOdhcpd := strings.CutPrefix(scanner.Text(), "# "); isOdhcpd {
+				mac, l, ok = parseOdhcpdLease(rest, now)
+			} else {
+				mac, l, ok = parseDnsmasqLease(scanner.Text(), now)
+			}
+			if ok {
+				leases[mac] = l
+			}
+		}
+		f.Close()
+	}
+	return leases, found
+}
+
+// parseDnsmasqLease parses a line of a dnsmasq lease file, being the
+// expiry time, MAC, IP address, hostname and client ID.
+func parseDnsmasqLease(line string, now time.Time) (string, lease, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return "", lease{}, false
+	}
+	expiry, err := strconv.ParseInt(fields[0], 10, 64)
+	// 0 is an infinite lease
+	if err != nil || (expiry != 0 && expiry < now.Unix()) {
+		return "", lease{}, false
+	}
+	mac, err := net.ParseMAC(fields[1])
+	if err != nil {
+		return "", lease{}, false
+	}
+	l := lease{ip: fields[2]}
+	if fields[3] != "*" {
+		l.hostname = fields[3]
+	}
+	return mac.String(), l, true
+}
+
+// parseOdhcpdLease parses a lease line of an odhcpd lease file, being the
+// interface, DUID or MAC, IAID or "ipv4", hostname, expiry time, assigned
+// ID, prefix length and addresses.
+//
+// Only DHCPv4 leases are returned, as DHCPv6 leases are keyed by DUID rather
+// than MAC.
+func parseOdhcpdLease(line string, now time.Time) (string, lease, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 || fields[2] != "ipv4" {
+		return "", lease{}, false
+	}
+	expiry, err := strconv.ParseInt(fields[4], 10, 64)
+	// -1 is an infinite lease
+	if err != nil || (expiry != -1 && expiry < now.Unix()) {
+		return "", lease{}, false
+	}
+	hwaddr, err := hexMAC(fields[1])
+	if err != nil {
+		return "", lease{}, false
+	}
+	l := lease{ip: strings.Split(fields[7], "/")[0]}
+	if fields[3] != "-" {
+		l.hostname = fields[3]
+	}
+	return hwaddr, l, true
+}
+
+// hexMAC parses a MAC formatted as hex digits without separators.
+func hexMAC(s string) (string, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	if len(b) != 6 {
+		return "", errors.Errorf("invalid MAC '%s'", s)
+	}
+	return net.HardwareAddr(b).String(), nil
+}
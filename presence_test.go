@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDnsmasqLease(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	patterns := []struct {
+		name  string
+		line  string
+		mac   string
+		lease lease
+		ok    bool
+	}{
+		{"active",
+			"1700003600 aa:bb:cc:dd:ee:01 192.168.1.10 phone 01:aa:bb:cc:dd:ee:01",
+			"aa:bb:cc:dd:ee:01", lease{ip: "192.168.1.10", hostname: "phone"}, true},
+		{"no hostname",
+			"1700003600 aa:bb:cc:dd:ee:02 192.168.1.11 * *",
+			"aa:bb:cc:dd:ee:02", lease{ip: "192.168.1.11"}, true},
+		{"infinite",
+			"0 aa:bb:cc:dd:ee:03 192.168.1.12 nas *",
+			"aa:bb:cc:dd:ee:03", lease{ip: "192.168.1.12", hostname: "nas"}, true},
+		{"upper case mac",
+			"1700003600 AA:BB:CC:DD:EE:04 192.168.1.13 tv *",
+			"aa:bb:cc:dd:ee:04", lease{ip: "192.168.1.13", hostname: "tv"}, true},
+		{"no client id",
+			"1700003600 aa:bb:cc:dd:ee:05 192.168.1.14 printer",
+			"aa:bb:cc:dd:ee:05", lease{ip: "192.168.1.14", hostname: "printer"}, true},
+		{"expired", "1699999999 aa:bb:cc:dd:ee:06 192.168.1.15 old *", "", lease{}, false},
+		{"bad expiry", "soon aa:bb:cc:dd:ee:07 192.168.1.16 host *", "", lease{}, false},
+		{"bad mac", "1700003600 aa:bb:cc 192.168.1.17 host *", "", lease{}, false},
+		{"short", "1700003600 aa:bb:cc:dd:ee:08 192.168.1.18", "", lease{}, false},
+		{"ipv6 duid", "duid 00:01:00:01:2c:5e:7a:1b:aa:bb:cc:dd:ee:09", "", lease{}, false},
+		{"empty", "", "", lease{}, false},
+	}
+	for _, p := range patterns {
+		t.Run(p.name, func(t *testing.T) {
+			mac, l, ok := parseDnsmasqLease(p.line, now)
+			if mac != p.mac || l != p.lease || ok != p.ok {
+				t.Errorf("got (%s, %+v, %v), want (%s, %+v, %v)", mac, l, ok, p.mac, p.lease, p.ok)
+			}
+		})
+	}
+}
+
+func TestParseOdhcpdLease(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	patterns := []struct {
+		name  string
+		line  string
+		mac   string
+		lease lease
+		ok    bool
+	}{
+		{"active",
+			"br-lan aabbccddee01 ipv4 laptop 1700003600 a1b 32 192.168.1.20/32",
+			"aa:bb:cc:dd:ee:01", lease{ip: "192.168.1.20", hostname: "laptop"}, true},
+		{"no hostname",
+			"br-lan aabbccddee02 ipv4 - 1700003600 a1c 32 192.168.1.21/32",
+			"aa:bb:cc:dd:ee:02", lease{ip: "192.168.1.21"}, true},
+		{"infinite",
+			"br-lan aabbccddee03 ipv4 nas -1 a1d 32 192.168.1.22/32",
+			"aa:bb:cc:dd:ee:03", lease{ip: "192.168.1.22", hostname: "nas"}, true},
+		{"no prefix",
+			"br-lan aabbccddee04 ipv4 tv 1700003600 a1e 32 192.168.1.23",
+			"aa:bb:cc:dd:ee:04", lease{ip: "192.168.1.23", hostname: "tv"}, true},
+		{"expired", "br-lan aabbccddee05 ipv4 old 1699999999 a1f 32 192.168.1.24/32", "", lease{}, false},
+		{"ipv6",
+			"br-lan 000100012c5e7a1baabbccddee06 3a4b5c6d phone 1700003600 200 64 fd00::200/64",
+			"", lease{}, false},
+		{"bad mac", "br-lan aabbccdd ipv4 host 1700003600 a20 32 192.168.1.25/32", "", lease{}, false},
+		{"bad expiry", "br-lan aabbccddee07 ipv4 host soon a21 32 192.168.1.26/32", "", lease{}, false},
+		{"short", "br-lan aabbccddee08 ipv4 host 1700003600", "", lease{}, false},
+		{"empty", "", "", lease{}, false},
+	}
+	for _, p := range patterns {
+		t.Run(p.name, func(t *testing.T) {
+			mac, l, ok := parseOdhcpdLease(p.line, now)
+			if mac != p.mac || l != p.lease || ok != p.ok {
+				t.Errorf("got (%s, %+v, %v), want (%s, %+v, %v)", mac, l, ok, p.mac, p.lease, p.ok)
+			}
+		})
+	}
+}
+
+func TestHexMAC(t *testing.T) {
+	patterns := []struct {
+		in  string
+		mac string
+		ok  bool
+	}{
+		{"aabbccddeeff", "aa:bb:cc:dd:ee:ff", true},
+		{"AABBCCDDEEFF", "aa:bb:cc:dd:ee:ff", true},
+		{"001122334455", "00:11:22:33:44:55", true},
+		{"", "", false},
+		{"aabbccddee", "", false},
+		{"aabbccddeeff00", "", false},
+		{"aabbccddeeg0", "", false},
+		{"aabbccddeef", "", false},
+		{"aa:bb:cc:dd:ee:ff", "", false},
+	}
+	for _, p := range patterns {
+		mac, err := hexMAC(p.in)
+		if mac != p.mac || (err == nil) != p.ok {
+			t.Errorf("'%s': got (%s, %v), want (%s, %v)", p.in, mac, err, p.mac, p.ok)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type probeConfig struct {
+	// The probe type - dns, tcp, http or icmp.
+	Type string
+	// The DNS server to query, as host[:port].
+	// Defaults to the system resolver.
+	Server string
+	// The name to lookup with a dns probe.
+	Query string
+	// The host:port to connect to with a tcp probe.
+	Address string
+	// The URL to GET with an http probe.
+	URL string `yaml:"url"`
+	// The HTTP status expected from an http probe.
+	Status int
+	// The host to ping with an icmp probe.
+	Host string
+	// The time allowed for the probe to succeed.
+	Timeout string
+}
+
+// the probes used if none are configured
+var defaultProbes = []probeConfig{
+	{Type: "dns", Server: "1.1.1.1", Query: "www.google.com"},
+	{Type: "dns", Server: "8.8.8.8", Query: "www.google.com"},
+	{Type: "dns", Server: "resolver1.opendns.com", Query: "www.google.com"},
+}
+
+// probe is a check of connectivity to a remote host.
+type probe struct {
+	name    string
+	timeout time.Duration
+	check   func(ctx context.Context) error
+}
+
+// probeSet is a set of probes that together determine if a link is up.
+type probeSet struct {
+	probes []probe
+	policy string
+	log    *slog.Logger
+}
+
+func newProbeSet(cfgs []probeConfig, policy string, bind probeBind, logger *slog.Logger) *probeSet {
+	switch policy {
+	case "any", "all", "quorum":
+	default:
+		log.Fatalf("unknown probe policy '%s'", policy)
+	}
+	ps := probeSet{policy: policy, log: logger}
+	for _, cfg := range cfgs {
+		ps.probes = append(ps.probes, newProbe(cfg, bind))
+	}
+	return &ps
+}
+
+func newProbe(cfg probeConfig, bind probeBind) probe {
+	p := probe{timeout: 10 * time.Second}
+	if len(cfg.Timeout) > 0 {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			log.Fatalf("error parsing probe timeout '%s': %v", cfg.Timeout, err)
+		}
+		p.timeout = timeout
+	}
+	switch cfg.Type {
+	case "dns":
+		if len(cfg.Query) == 0 {
+			log.Fatal("dns probe requires a query")
+		}
+		p.name = "dns " + cfg.Query
+		if len(cfg.Server) > 0 {
+			p.name += "@" + cfg.Server
+		}
+		p.check = dnsProbe(cfg.Server, cfg.Query, bind)
+	case "tcp":
+		if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+			log.Fatalf("error parsing tcp probe address '%s': %v", cfg.Address, err)
+		}
+		p.name = "tcp " + cfg.Address
+		p.check = tcpProbe(cfg.Address, bind)
+	case "http":
+		if len(cfg.URL) == 0 {
+			log.Fatal("http probe requires a url")
+		}
+		status := cfg.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		p.name = "http " + cfg.URL
+		p.check = httpProbe(cfg.URL, status, bind)
+	case "icmp":
+		if len(cfg.Host) == 0 {
+			log.Fatal("icmp probe requires a host")
+		}
+		p.name = "icmp " + cfg.Host
+		p.check = icmpProbe(cfg.Host, bind)
+	default:
+		log.Fatalf("unknown probe type '%s'", cfg.Type)
+	}
+	return p
+}
+
+// check runs the probes concurrently and returns true if sufficient
+// succeed to satisfy the policy.
+func (ps *probeSet) check() bool {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	passed := 0
+	for _, p := range ps.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+			defer cancel()
+			if err := p.check(ctx); err != nil {
+				ps.log.Debug("probe failed", "probe", p.name, "err", err)
+				return
+			}
+			mu.Lock()
+			passed++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	switch ps.policy {
+	case "all":
+		return passed == len(ps.probes)
+	case "quorum":
+		return passed > len(ps.probes)/2
+	default:
+		return passed > 0
+	}
+}
+
+func dnsProbe(server, query string, bind probeBind) func(context.Context) error {
+	r := net.DefaultResolver
+	if len(server) > 0 {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+	}
+	if len(server) > 0 || bind.bound() {
+		d := bind.dialer()
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				if len(server) > 0 {
+					address = server
+				}
+				return d.DialContext(ctx, network, address)
+			},
+		}
+	}
+	return func(ctx context.Context) error {
+		_, err := r.LookupHost(ctx, query)
+		return err
+	}
+}
+
+func tcpProbe(address string, bind probeBind) func(context.Context) error {
+	d := bind.dialer()
+	return func(ctx context.Context) error {
+		c, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+}
+
+func httpProbe(url string, status int, bind probeBind) func(context.Context) error {
+	client := http.DefaultClient
+	if bind.bound() {
+		client = &http.Client{
+			Transport: &http.Transport{DialContext: bind.dialer().DialContext},
+		}
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			return errors.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+func icmpProbe(host string, bind probeBind) func(context.Context) error {
+	return func(ctx context.Context) error {
+		c, err := dialPing(ctx, host, bind)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		deadline, _ := ctx.Deadline()
+		_, err = c.echo(1, deadline)
+		return err
+	}
+}
+
+// probeBind binds probe sockets to an interface and/or source address, so
+// the probes test a particular uplink.
+type probeBind struct {
+	iface  string
+	source net.IP
+}
+
+func (b probeBind) bound() bool {
+	return len(b.iface) > 0 || b.source != nil
+}
+
+// dialer returns a dialer that binds its sockets, including those used to
+// resolve names.
+func (b probeBind) dialer() *net.Dialer {
+	d := net.Dialer{}
+	if b.bound() {
+		d.Control = b.control
+		d.Resolver = b.resolver()
+	}
+	return &d
+}
+
+// resolver returns a resolver that binds its sockets, so names are resolved
+// via the uplink.
+func (b probeBind) resolver() *net.Resolver {
+	if !b.bound() {
+		return net.DefaultResolver
+	}
+	d := net.Dialer{Control: b.control}
+	return &net.Resolver{PreferGo: true, Dial: d.DialContext}
+}
+
+func (b probeBind) control(_, _ string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) { err = b.bind(int(fd)) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// bind binds the socket to the interface and source address.
+//
+// Binding to an interface requires CAP_NET_RAW on kernels prior to 5.7.
+func (b probeBind) bind(fd int) error {
+	if len(b.iface) > 0 {
+		if err := syscall.BindToDevice(fd, b.iface); err != nil {
+			return errors.Wrapf(err, "unable to bind to %s", b.iface)
+		}
+	}
+	if b.source == nil {
+		return nil
+	}
+	var sa syscall.Sockaddr
+	if ip4 := b.source.To4(); ip4 != nil {
+		sa = &syscall.SockaddrInet4{Addr: [4]byte(ip4)}
+	} else {
+		sa = &syscall.SockaddrInet6{Addr: [16]byte(b.source.To16())}
+	}
+	return errors.Wrapf(syscall.Bind(fd, sa), "unable to bind to %s", b.source)
+}
+
+// pingConn is an unprivileged ICMP echo socket connected to a host.
+//
+// Requires the process group to be within net.ipv4.ping_group_range.
+type pingConn struct {
+	conn net.PacketConn
+	addr *net.UDPAddr
+	v6   bool
+}
+
+// dialPing resolves the host and opens a ping socket to it.
+func dialPing(ctx context.Context, host string, bind probeBind) (*pingConn, error) {
+	ips, err := bind.resolver().LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.Errorf("no address for %s", host)
+	}
+	ip := ips[0].IP
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	v6 := ip.To4() == nil
+	if v6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, errors.Wrap(err, "ping socket unavailable")
+	}
+	if err = bind.bind(fd); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "ping")
+	conn, err := net.FilePacketConn(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &pingConn{conn: conn, addr: &net.UDPAddr{IP: ip, Zone: ips[0].Zone}, v6: v6}, nil
+}
+
+func (c *pingConn) Close() error {
+	return c.conn.Close()
+}
+
+// echo sends an echo request with the sequence number and waits for the
+// matching reply, returning the round trip time.
+//
+// The kernel sets the identifier and checksum for ping sockets.
+func (c *pingConn) echo(seq uint16, deadline time.Time) (time.Duration, error) {
+	reqType, replyType := byte(8), byte(0)
+	if c.v6 {
+		reqType, replyType = 128, 129
+	}
+	msg := make([]byte, 16)
+	msg[0] = reqType
+	binary.BigEndian.PutUint16(msg[6:8], seq)
+	if !deadline.IsZero() {
+		c.conn.SetDeadline(deadline)
+	}
+	start := time.Now()
+	if _, err := c.conn.WriteTo(msg, c.addr); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		if n >= 8 && buf[0] == replyType && binary.BigEndian.Uint16(buf[6:8]) == seq {
+			return time.Since(start), nil
+		}
+	}
+}
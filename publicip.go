@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type ipMethodConfig struct {
+	// The method type - dns, http or interface.
+	Type string
+	// The DNS server to query, as host[:port].
+	Server string
+	// The name to lookup with a dns method.
+	Query string
+	// The DNS record type - a, aaaa or txt.
+	// Defaults to a for IPv4 and aaaa for IPv6.
+	Record string
+	// The URL of an HTTP service that returns the client address as text.
+	URL string `yaml:"url"`
+	// The interface to read the address from.
+	Interface string
+	// The time allowed for the method to succeed.
+	Timeout string
+}
+
+// the methods used if none are configured
+var defaultIPMethods = []ipMethodConfig{
+	{Type: "dns", Server: "resolver1.opendns.com", Query: "myip.opendns.com"},
+}
+
+// ipFinder finds the public address of one IP family, trying each method in
+// turn until one succeeds.
+type ipFinder struct {
+	family  string
+	methods []ipMethod
+	log     *slog.Logger
+}
+
+type ipMethod struct {
+	source  string
+	timeout time.Duration
+	lookup  func(ctx context.Context) ([]string, error)
+}
+
+// newIPFinder creates an ipFinder for the family, either ipv4 or ipv6.
+func newIPFinder(family string, cfgs []ipMethodConfig, logger *slog.Logger) *ipFinder {
+	f := ipFinder{family: family, log: logger}
+	// the network suffix used to restrict connections to the family
+	nv := "4"
+	if family == "ipv6" {
+		nv = "6"
+	}
+	for _, cfg := range cfgs {
+		m := ipMethod{timeout: 10 * time.Second}
+		if len(cfg.Timeout) > 0 {
+			timeout, err := time.ParseDuration(cfg.Timeout)
+			if err != nil {
+				log.Fatalf("error parsing wan ip timeout '%s': %v", cfg.Timeout, err)
+			}
+			m.timeout = timeout
+		}
+		switch cfg.Type {
+		case "dns":
+			if len(cfg.Server) == 0 || len(cfg.Query) == 0 {
+				log.Fatal("wan ip dns method requires a server and query")
+			}
+			record := cfg.Record
+			if len(record) == 0 {
+				record = "a"
+				if nv == "6" {
+					record = "aaaa"
+				}
+			}
+			if record != "a" && record != "aaaa" && record != "txt" {
+				log.Fatalf("unknown wan ip dns record '%s'", cfg.Record)
+			}
+			m.source = fmt.Sprintf("dns %s %s@%s", record, cfg.Query, cfg.Server)
+			m.lookup = dnsIPLookup(nv, cfg.Server, cfg.Query, record)
+		case "http":
+			if len(cfg.URL) == 0 {
+				log.Fatal("wan ip http method requires a url")
+			}
+			m.source = "http " + cfg.URL
+			m.lookup = httpIPLookup(nv, cfg.URL)
+		case "interface":
+			if len(cfg.Interface) == 0 {
+				log.Fatal("wan ip interface method requires an interface")
+			}
+			m.source = "interface " + cfg.Interface
+			m.lookup = ifIPLookup(cfg.Interface)
+		default:
+			log.Fatalf("unknown wan ip method type '%s'", cfg.Type)
+		}
+		f.methods = append(f.methods, m)
+	}
+	return &f
+}
+
+// find returns the public address and the source of the method that found
+// it, or empty strings if no method succeeds.
+func (f *ipFinder) find() (string, string) {
+	for _, m := range f.methods {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		addrs, err := m.lookup(ctx)
+		cancel()
+		if err != nil {
+			f.log.Debug("public ip lookup failed", "family", f.family, "source", m.source, "err", err)
+			continue
+		}
+		for _, a := range addrs {
+			ip := net.ParseIP(strings.TrimSpace(a))
+			if ip == nil || !ip.IsGlobalUnicast() || (ip.To4() != nil) != (f.family == "ipv4") {
+				continue
+			}
+			return ip.String(), m.source
+		}
+		f.log.Debug("public ip lookup found no address", "family", f.family, "source", m.source)
+	}
+	return "", ""
+}
+
+// dnsIPLookup queries a DNS server that returns the address of the client
+// for the query, such as OpenDNS for myip.opendns.com, or Google for
+// o-o.myaddr.l.google.com TXT.
+//
+// The server is contacted using the family being looked up.
+func dnsIPLookup(nv, server, query, record string) func(context.Context) ([]string, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	r := net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, network+nv, server)
+		},
+	}
+	return func(ctx context.Context) ([]string, error) {
+		if record == "txt" {
+			return r.LookupTXT(ctx, query)
+		}
+		network := "ip4"
+		if record == "aaaa" {
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, query)
+		if err != nil {
+			return nil, err
+		}
+		addrs := []string{}
+		for _, ip := range ips {
+			addrs = append(addrs, ip.String())
+		}
+		return addrs, nil
+	}
+}
+
+// httpIPLookup requests the client address from an HTTP echo service, such
+// as https://api64.ipify.org, using the family being looked up.
+func httpIPLookup(nv, url string) func(context.Context) ([]string, error) {
+	d := net.Dialer{}
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return d.DialContext(ctx, "tcp"+nv, addr)
+			},
+		},
+	}
+	return func(ctx context.Context) ([]string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+		if err != nil {
+			return nil, err
+		}
+		return []string{string(body)}, nil
+	}
+}
+
+// the shared address space used by carrier-grade NAT, from RFC 6598
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// ifIPLookup returns the public addresses of an interface, such as the
+// PPP interface of a router.
+//
+// Private and shared (CGNAT) addresses are ignored, so a NATed interface
+// provides no address.
+func ifIPLookup(name string) func(context.Context) ([]string, error) {
+	return func(context.Context) ([]string, error) {
+		iface, err := hostInterface(name)
+		if err != nil {
+			return nil, err
+		}
+		ifAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		addrs := []string{}
+		for _, a := range ifAddrs {
+			if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsPrivate() && !cgnatNet.Contains(ipn.IP) {
+				addrs = append(addrs, ipn.IP.String())
+			}
+		}
+		return addrs, nil
+	}
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterModule("self", newSelf)
+}
+
+// Counters of daemon activity, as reported by the self module.
+var (
+	publishCount    atomic.Uint64
+	publishFailures atomic.Uint64
+	connectCount    atomic.Uint64
+	// unix time of the last successful connect to the broker
+	lastConnect atomic.Int64
+)
+
+// the supported self entities, in message order
+var selfEnts = []string{
+	"rss",
+	"cpu_time",
+	"goroutines",
+	"open_fds",
+	"publishes",
+	"publish_failures",
+	"reconnects",
+	"last_connect",
+	"panics",
+}
+
+type selfConfig struct {
+	pollerConfig `yaml:",inline"`
+	Entities     []string
+}
+
+type self struct {
+	PolledSensor
+	entities map[string]bool
+	msg      string
+	stopic   string
+}
+
+func newSelf(mod *Module, yamlCfg *yaml.Node) SyncCloser {
+	cfg := selfConfig{
+		pollerConfig: pollerConfig{Period: "1m"},
+		Entities:     []string{"rss", "cpu_time"},
+	}
+	err := yamlCfg.Decode(&cfg)
+	if err != nil {
+		log.Fatalf("error reading self config: %v", err)
+	}
+	entities := map[string]bool{}
+	for _, e := range cfg.Entities {
+		if !slices.Contains(selfEnts, e) {
+			log.Fatalf("unsupported self entity: %s", e)
+		}
+		entities[e] = true
+	}
+	s := self{entities: entities, stopic: mod.StateTopic("")}
+	s.poller = mod.NewPoller(&cfg.pollerConfig, s.Refresh)
+	return &s
+}
+
+func (s *self) Config() []EntityConfig {
+	var config []EntityConfig
+	for _, e := range selfEnts {
+		if !s.entities[e] {
+			continue
+		}
+		cfg := map[string]any{
+			"name":           "dunnart " + strings.ReplaceAll(e, "_", " "),
+			"state_topic":    s.stopic,
+			"value_template": fmt.Sprintf("{{value_json.%s}}", e),
+		}
+		switch e {
+		case "rss":
+			cfg["name"] = "dunnart RSS"
+			cfg["device_class"] = "data_size"
+			cfg["unit_of_measurement"] = "kB"
+			cfg["state_class"] = "measurement"
+			cfg["icon"] = "mdi:memory"
+		case "cpu_time":
+			cfg["name"] = "dunnart CPU time"
+			cfg["device_class"] = "duration"
+			cfg["unit_of_measurement"] = "s"
+			cfg["state_class"] = "total_increasing"
+		case "goroutines":
+			cfg["state_class"] = "measurement"
+			cfg["icon"] = "mdi:format-list-numbered"
+		case "open_fds":
+			cfg["name"] = "dunnart open files"
+			cfg["state_class"] = "measurement"
+			cfg["icon"] = "mdi:file-multiple-outline"
+		case "last_connect":
+			cfg["device_class"] = "timestamp"
+		default:
+			cfg["state_class"] = "total_increasing"
+			cfg["icon"] = "mdi:counter"
+		}
+		setFieldAvailability(cfg, s.stopic, e)
+		config = append(config, EntityConfig{e, "sensor", cfg})
+	}
+	return config
+}
+
+func (s *self) Publish() {
+	s.ps.Publish(s.topic, s.msg)
+}
+
+func (s *self) Refresh(forced bool) {
+	fields := []string{}
+	for _, e := range selfEnts {
+		if !s.entities[e] {
+			continue
+		}
+		switch e {
+		case "rss":
+			if rss, err := selfRSS(); err == nil {
+				fields = append(fields, fmt.Sprintf(`"rss": %d`, rss/1024))
+			}
+		case "cpu_time":
+			var ru syscall.Rusage
+			if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err == nil {
+				cpu := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+				fields = append(fields, fmt.Sprintf(`"cpu_time": %.2f`, cpu.Seconds()))
+			}
+		case "goroutines":
+			fields = append(fields, fmt.Sprintf(`"goroutines": %d`, runtime.NumGoroutine()))
+		case "open_fds":
+			if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+				fields = append(fields, fmt.Sprintf(`"open_fds": %d`, len(fds)))
+			}
+		case "publishes":
+			fields = append(fields, fmt.Sprintf(`"publishes": %d`, publishCount.Load()))
+		case "publish_failures":
+			fields = append(fields, fmt.Sprintf(`"publish_failures": %d`, publishFailures.Load()))
+		case "reconnects":
+			reconnects := uint64(0)
+			if c := connectCount.Load(); c > 0 {
+				reconnects = c - 1
+			}
+			fields = append(fields, fmt.Sprintf(`"reconnects": %d`, reconnects))
+		case "panics":
+			fields = append(fields, fmt.Sprintf(`"panics": %d`, panicCount.Load()))
+		case "last_connect":
+			if lc := lastConnect.Load(); lc != 0 {
+				t := time.Unix(lc, 0).UTC().Format(time.RFC3339)
+				fields = append(fields, fmt.Sprintf(`"last_connect": "%s"`, t))
+			}
+		}
+	}
+	msg := "{" + strings.Join(fields, ", ") + "}"
+	if msg != s.msg || forced {
+		s.msg = msg
+		s.Publish()
+	}
+}
+
+// selfRSS returns the resident set size of the daemon, in bytes.
+func selfRSS() (uint64, error) {
+	v, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(v))
+	if len(fields) < 2 {
+		return 0, errors.Errorf("bad statm: %s", v)
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * uint64(os.Getpagesize()), nil
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type stateConfig struct {
+	// The directory containing the persistent state files.
+	Dir string
+	// The minimum period between saves of a state file, to limit wear on
+	// flash storage.  State is always saved on exit.
+	SavePeriod string `yaml:"save_period"`
+}
+
+// the persistent state settings, set from the state config.
+var (
+	stateDir        = "/var/lib/dunnart"
+	stateSavePeriod = 15 * time.Minute
+)
+
+func setupState(cfg *stateConfig) {
+	if len(cfg.Dir) > 0 {
+		stateDir = cfg.Dir
+	}
+	if len(cfg.SavePeriod) > 0 {
+		period, err := time.ParseDuration(cfg.SavePeriod)
+		if err != nil {
+			log.Fatalf("error parsing state save_period '%s': %v", cfg.SavePeriod, err)
+		}
+		stateSavePeriod = period
+	}
+}
+
+// stateFile is a file holding persistent state, such as counter totals, as
+// JSON.
+//
+// A stateFile is not safe for concurrent use.
+type stateFile struct {
+	path     string
+	lastSave time.Time
+}
+
+func newStateFile(name string) *stateFile {
+	return &stateFile{path: filepath.Join(stateDir, name+".json")}
+}
+
+// load reads the state into v.
+//
+// A missing state file is not an error, and leaves v unchanged.
+func (s *stateFile) load(v any) error {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return errors.Wrapf(json.Unmarshal(b, v), "error parsing %s", s.path)
+}
+
+// save writes v to the state file, if forced or the save period has elapsed
+// since the last save.
+//
+// The file is replaced atomically, so a crash mid-save leaves the previous
+// state intact.
+func (s *stateFile) save(v any, forced bool) error {
+	now := time.Now()
+	if !forced && now.Sub(s.lastSave) < stateSavePeriod {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.lastSave = now
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 	"os/exec"
 	"sort"
 	"strings"
+	"syscall"
 
 	"gopkg.in/yaml.v3"
 )
@@ -29,6 +30,7 @@ type systemInfo struct {
 	PolledSensor
 	entities []string
 	msg      string
+	stopic   string
 }
 
 // mapping from entity name to HA display name
@@ -52,15 +54,15 @@ var osrEnts = map[string]string{
 	"os_version": "VERSION",
 }
 
-// mapping from entity to uname option to generate it
-var unameEnts = map[string]string{
-	"machine":        "-m",
-	"kernel_name":    "-s",
-	"kernel_release": "-r",
-	"kernel_version": "-v",
+// mapping from entity to the uname field providing it
+var unameEnts = map[string]func(*syscall.Utsname) string{
+	"machine":        func(u *syscall.Utsname) string { return utsString(u.Machine[:]) },
+	"kernel_name":    func(u *syscall.Utsname) string { return utsString(u.Sysname[:]) },
+	"kernel_release": func(u *syscall.Utsname) string { return utsString(u.Release[:]) },
+	"kernel_version": func(u *syscall.Utsname) string { return utsString(u.Version[:]) },
 }
 
-func newSystemInfo(yamlCfg *yaml.Node) SyncCloser {
+func newSystemInfo(mod *Module, yamlCfg *yaml.Node) SyncCloser {
 	cfg := systemInfoConfig{
 		pollerConfig: pollerConfig{Period: "6h"},
 		Entities:     []string{"kernel_release", "os_release"},
@@ -71,8 +73,8 @@ func newSystemInfo(yamlCfg *yaml.Node) SyncCloser {
 	}
 	entities := cfg.Entities
 	sort.Strings(entities)
-	si := systemInfo{entities: entities}
-	si.poller = NewPoller(&cfg.pollerConfig, si.Refresh)
+	si := systemInfo{entities: entities, stopic: mod.StateTopic("")}
+	si.poller = mod.NewPoller(&cfg.pollerConfig, si.Refresh)
 	return &si
 }
 
@@ -81,7 +83,7 @@ func (s *systemInfo) Config() []EntityConfig {
 	for _, e := range s.entities {
 		cfg := map[string]any{
 			"name":           ents[e],
-			"state_topic":    "~/sys_info",
+			"state_topic":    s.stopic,
 			"value_template": fmt.Sprintf("{{value_json.%s}}", e),
 		}
 		switch e {
@@ -95,6 +97,7 @@ func (s *systemInfo) Config() []EntityConfig {
 		default:
 			cfg["icon"] = "mdi:information-outline"
 		}
+		setFieldAvailability(cfg, s.stopic, e)
 		if e == "apt_status" || e == "pacman_status" {
 			config = append(config, EntityConfig{e, "binary_sensor", cfg})
 		} else {
@@ -110,7 +113,11 @@ func (s *systemInfo) Publish() {
 }
 
 func osRelease() (map[string]string, error) {
-	f, err := os.Open("/etc/os-release")
+	f, err := os.Open(hostPath("/etc/os-release"))
+	if os.IsNotExist(err) {
+		// absolute symlinks, and systems without /etc/os-release
+		f, err = os.Open(hostPath("/usr/lib/os-release"))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -148,6 +155,19 @@ func pacmanCheckUpdates() int {
 	return 1
 }
 
+// utsString converts a NUL terminated uname field to a string.
+// The field type is architecture dependent.
+func utsString[T int8 | uint8](f []T) string {
+	b := make([]byte, 0, len(f))
+	for _, c := range f {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
 func unquote(s string) string {
 	if len(s) > 0 && s[0] == '"' {
 		s = s[1:]
@@ -160,6 +180,7 @@ func unquote(s string) string {
 
 func (s *systemInfo) Refresh(_ bool) {
 	var osr map[string]string
+	var uts *syscall.Utsname
 	apu := -1
 
 	fields := []string{}
@@ -175,10 +196,16 @@ func (s *systemInfo) Refresh(_ bool) {
 			}
 			continue
 		}
-		if unameOpt, ok := unameEnts[e]; ok {
-			cmd := exec.Command("uname", unameOpt)
-			if v, err := cmd.Output(); err == nil {
-				fields = append(fields, fmt.Sprintf(`"%s": "%s"`, e, strings.TrimSpace(string(v))))
+		if unameField, ok := unameEnts[e]; ok {
+			// the kernel is shared with any container, so no need for hostPath
+			if uts == nil {
+				var u syscall.Utsname
+				if err := syscall.Uname(&u); err == nil {
+					uts = &u
+				}
+			}
+			if uts != nil {
+				fields = append(fields, fmt.Sprintf(`"%s": "%s"`, e, unameField(uts)))
 			}
 			continue
 		}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a state notification to systemd.
+//
+// Does nothing if not running as a systemd notify service.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if len(socket) == 0 {
+		return
+	}
+	// net maps a leading @ to the abstract namespace
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("sd_notify failed", "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		slog.Warn("sd_notify failed", "err", err)
+	}
+}
+
+// sdWatchdogTimeout returns the watchdog timeout set by systemd,
+// or 0 if the watchdog is not enabled for this process.
+func sdWatchdogTimeout() time.Duration {
+	if pid, ok := os.LookupEnv("WATCHDOG_PID"); ok && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseUint(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// sdWatchdog pings the systemd watchdog while the pollers are alive.
+//
+// If a poller becomes stuck the pings stop and systemd restarts the daemon.
+func sdWatchdog(done <-chan struct{}) {
+	timeout := sdWatchdogTimeout()
+	if timeout == 0 {
+		return
+	}
+	t := time.NewTicker(timeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if pollersAlive(timeout) {
+				sdNotify("WATCHDOG=1")
+			} else {
+				slog.Error("poller stuck - withholding watchdog ping")
+			}
+		case <-done:
+			return
+		}
+	}
+}
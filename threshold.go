@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+type thresholdConfig struct {
+	// The entity whose value is checked.
+	Entity string
+	// The value the entity must exceed to trigger a problem.
+	Above *float64
+	// The value the entity must drop below to trigger a problem.
+	Below *float64
+	// The period the limit must be continuously exceeded to trigger.
+	Hold string
+	// The amount the value must return past the limit to clear the problem.
+	Hysteresis float64
+}
+
+type threshold struct {
+	name       string
+	entity     string
+	above      bool
+	limit      float64
+	hysteresis float64
+	hold       time.Duration
+	// the time the limit was first exceeded, while the trigger is pending.
+	pending time.Time
+	active  bool
+	// the triggering value and time, while active
+	value float64
+	since time.Time
+}
+
+// exceeded returns true if the value is beyond the limit.
+func (t *threshold) exceeded(v float64) bool {
+	if t.above {
+		return v > t.limit
+	}
+	return v < t.limit
+}
+
+// cleared returns true if the value has returned past the limit by at least
+// the hysteresis.
+func (t *threshold) cleared(v float64) bool {
+	if t.above {
+		return v < t.limit-t.hysteresis
+	}
+	return v > t.limit+t.hysteresis
+}
+
+// update updates the state of the threshold given the current value,
+// returning true if the state has changed.
+func (t *threshold) update(v float64, now time.Time) bool {
+	if t.active {
+		if t.cleared(v) {
+			t.active = false
+			t.pending = time.Time{}
+			return true
+		}
+		return false
+	}
+	if !t.exceeded(v) {
+		t.pending = time.Time{}
+		return false
+	}
+	if t.pending.IsZero() {
+		t.pending = now
+	}
+	if now.Sub(t.pending) < t.hold {
+		return false
+	}
+	t.active = true
+	t.value = v
+	t.since = now
+	return true
+}
+
+func (t *threshold) field() string {
+	if t.active {
+		return fmt.Sprintf(`"%s": {"state": "on", "value": %.2f, "since": "%s"}`,
+			t.name, t.value, t.since.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf(`"%s": {"state": "off"}`, t.name)
+}
+
+// thresholds evaluates threshold rules against the values of a module,
+// and publishes the resulting problem states.
+//
+// Rules are evaluated locally so the problem state is available in the
+// payload independent of HA.
+//
+// A nil thresholds, i.e. with no rules, is valid and does nothing.
+type thresholds struct {
+	tt []*threshold
+	// prefix for entity names, to distinguish rules inherited by several
+	// components of a module, such as interfaces.
+	prefix string
+	// topic relative to the module topic
+	topic string
+	// topic relative to the base topic, for entity config
+	stopic string
+	msg    string
+}
+
+// newThresholds creates the thresholds for the rules, which may only be
+// applied to the named entities.
+func newThresholds(cfg map[string]thresholdConfig, entities []string, prefix, topic, stopic string) *thresholds {
+	if len(cfg) == 0 {
+		return nil
+	}
+	tt := []*threshold{}
+	for _, name := range slices.Sorted(maps.Keys(cfg)) {
+		c := cfg[name]
+		if len(c.Entity) == 0 {
+			log.Fatalf("threshold %s: entity not set", name)
+		}
+		if !slices.Contains(entities, c.Entity) {
+			log.Fatalf("threshold %s: unknown entity '%s'", name, c.Entity)
+		}
+		if (c.Above == nil) == (c.Below == nil) {
+			log.Fatalf("threshold %s: exactly one of above or below must be set", name)
+		}
+		t := threshold{name: name, entity: c.Entity, hysteresis: c.Hysteresis}
+		if c.Above != nil {
+			t.above = true
+			t.limit = *c.Above
+		} else {
+			t.limit = *c.Below
+		}
+		if len(c.Hold) > 0 {
+			hold, err := time.ParseDuration(c.Hold)
+			if err != nil {
+				log.Fatalf("error parsing threshold %s hold '%s': %v", name, c.Hold, err)
+			}
+			t.hold = hold
+		}
+		tt = append(tt, &t)
+	}
+	return &thresholds{
+		tt:     tt,
+		prefix: prefix,
+		topic:  topic + "/problem",
+		stopic: stopic + "/problem",
+	}
+}
+
+// update evaluates the rules against the current values, publishing the
+// problem states if any have changed.
+//
+// Rules for values that are unavailable retain their current state.
+func (t *thresholds) update(ps PubSub, values map[string]float64, now time.Time) {
+	if t == nil {
+		return
+	}
+	changed := len(t.msg) == 0
+	for _, th := range t.tt {
+		if v, ok := values[th.entity]; ok {
+			if th.update(v, now) {
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return
+	}
+	fields := []string{}
+	for _, th := range t.tt {
+		fields = append(fields, th.field())
+	}
+	t.msg = "{" + strings.Join(fields, ", ") + "}"
+	t.Publish(ps)
+}
+
+// Publish publishes the current problem states.
+func (t *thresholds) Publish(ps PubSub) {
+	if t == nil || len(t.msg) == 0 {
+		return
+	}
+	ps.Publish(t.topic, t.msg)
+}
+
+// Config returns the entity config for the problem binary sensors.
+func (t *thresholds) Config() []EntityConfig {
+	if t == nil {
+		return nil
+	}
+	var config []EntityConfig
+	for _, th := range t.tt {
+		cfg := map[string]any{
+			"name":                     strings.ReplaceAll(t.prefix+th.name, "_", " "),
+			"state_topic":              t.stopic,
+			"value_template":           fmt.Sprintf("{{value_json.%s.state}}", th.name),
+			"device_class":             "problem",
+			"payload_on":               "on",
+			"payload_off":              "off",
+			"json_attributes_topic":    t.stopic,
+			"json_attributes_template": fmt.Sprintf("{{value_json.%s | tojson}}", th.name),
+		}
+		config = append(config, EntityConfig{"threshold-" + t.prefix + th.name, "binary_sensor", cfg})
+	}
+	return config
+}
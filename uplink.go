@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type wanUplinkConfig struct {
+	// The interface the uplink probes are bound to.
+	Interface string
+	// The source address the uplink probes are sent from.
+	Source string
+	// The probes used to determine if the uplink is up.
+	// Defaults to the link probes.
+	Probes []probeConfig
+	// How many probes must succeed for the uplink to be up.
+	// Defaults to the link policy.
+	Policy string
+}
+
+// uplink is one of several WAN connections, such as the primary and backup
+// uplinks of a multi-WAN router, probed independently of the default route.
+type uplink struct {
+	name   string
+	iface  string
+	probes *probeSet
+	online bool
+	msg    string
+}
+
+// newUplinks creates the uplinks, sorted by name.
+func newUplinks(cfgs map[string]wanUplinkConfig, link *wanLinkConfig, logger *slog.Logger) []*uplink {
+	uu := []*uplink{}
+	for name, cfg := range cfgs {
+		bind := probeBind{iface: cfg.Interface}
+		if len(cfg.Source) > 0 {
+			bind.source = net.ParseIP(cfg.Source)
+			if bind.source == nil {
+				log.Fatalf("error parsing wan uplink %s source '%s'", name, cfg.Source)
+			}
+		}
+		if !bind.bound() {
+			log.Fatalf("wan uplink %s requires an interface or source", name)
+		}
+		probes := cfg.Probes
+		if len(probes) == 0 {
+			probes = link.Probes
+		}
+		policy := cfg.Policy
+		if len(policy) == 0 {
+			policy = link.Policy
+		}
+		uu = append(uu, &uplink{
+			name:   name,
+			iface:  cfg.Interface,
+			probes: newProbeSet(probes, policy, bind, logger.With("uplink", name)),
+		})
+	}
+	slices.SortFunc(uu, func(a, b *uplink) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return uu
+}
+
+// checkUplinks probes the uplinks concurrently.
+func checkUplinks(uu []*uplink) {
+	var wg sync.WaitGroup
+	for _, u := range uu {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u.online = u.probes.check()
+		}()
+	}
+	wg.Wait()
+}
+
+// defaultRouteMsg returns the state message for the default route, being
+// the uplink, or interface if not an uplink, that holds the default route.
+//
+// The IPv4 default route is preferred over IPv6.
+func defaultRouteMsg(uu []*uplink) string {
+	iface, ok := defaultRouteIf()
+	if !ok {
+		return "{}"
+	}
+	name := iface
+	for _, u := range uu {
+		if u.iface == iface {
+			name = u.name
+			break
+		}
+	}
+	return fmt.Sprintf(`{"uplink": "%s", "interface": "%s"}`, name, iface)
+}
+
+// defaultRouteIf returns the interface of the default route with the lowest
+// metric.
+func defaultRouteIf() (string, bool) {
+	if iface, ok := readDefaultRoute("/proc/net/route", parseRouteLine); ok {
+		return iface, true
+	}
+	return readDefaultRoute("/proc/net/ipv6_route", parseIPv6RouteLine)
+}
+
+// readDefaultRoute reads the default route with the lowest metric from a
+// route table, using the parser to extract the interface and metric of
+// default routes.
+func readDefaultRoute(path string, parse func([]string) (string, uint64, bool)) (string, bool) {
+	f, err := os.Open(hostPath(path))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	iface := ""
+	var metric uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		ifname, m, ok := parse(strings.Fields(scanner.Text()))
+		if ok && (len(iface) == 0 || m < metric) {
+			iface, metric = ifname, m
+		}
+	}
+	return iface, len(iface) > 0
+}
+
+// the route is up, from linux/route.h
+const rtfUp = 0x1
+
+// parseRouteLine parses a line of /proc/net/route, being the interface,
+// destination, gateway, flags, refcnt, use, metric and mask.
+func parseRouteLine(fields []string) (string, uint64, bool) {
+	if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+		return "", 0, false
+	}
+	flags, err := strconv.ParseUint(fields[3], 16, 32)
+	if err != nil || flags&rtfUp == 0 {
+		return "", 0, false
+	}
+	metric, err := strconv.ParseUint(fields[6], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return fields[0], metric, true
+}
+
+// parseIPv6RouteLine parses a line of /proc/net/ipv6_route, being the
+// destination, prefix length, source, source prefix length, next hop,
+// metric, refcnt, use, flags and interface.
+//
+// Unreachable default routes, which are on the loopback interface, are
+// ignored.
+func parseIPv6RouteLine(fields []string) (string, uint64, bool) {
+	if len(fields) < 10 || fields[1] != "00" || strings.Trim(fields[0], "0") != "" || fields[9] == "lo" {
+		return "", 0, false
+	}
+	flags, err := strconv.ParseUint(fields[8], 16, 32)
+	if err != nil || flags&rtfUp == 0 {
+		return "", 0, false
+	}
+	metric, err := strconv.ParseUint(fields[5], 16, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return fields[9], metric, true
+}
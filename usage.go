@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type usageConfig struct {
+	// The day of the month the billing month starts.
+	// Days beyond the end of a month are taken as the last day of that month.
+	ResetDay int `yaml:"reset_day"`
+	// The timezone of the day and month boundaries.
+	// Defaults to local time.
+	Timezone string
+	// The data allowance for the billing month, e.g. 100GB.
+	Quota string
+	// The percentage of the quota used that triggers the usage_cap problem.
+	CapPercent float64 `yaml:"cap_percent"`
+}
+
+// usagePeriod is the data used within a day or billing month.
+type usagePeriod struct {
+	// the start date of the period, YYYY-MM-DD
+	Start string `json:"start"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// add adds data to the period, first resetting it if a new period has
+// started.
+//
+// Returns true if a new period has started.
+func (p *usagePeriod) add(start time.Time, bytes uint64) bool {
+	rolled := false
+	if s := start.Format(time.DateOnly); s != p.Start {
+		rolled = len(p.Start) > 0
+		p.Start = s
+		p.Bytes = 0
+	}
+	p.Bytes += bytes
+	return rolled
+}
+
+// usage tracks the data used by an interface in the current day and billing
+// month.
+type usage struct {
+	resetDay int
+	loc      *time.Location
+	// 0 if unlimited
+	quota uint64
+}
+
+func newUsage(cfg *usageConfig) *usage {
+	u := usage{resetDay: 1, loc: time.Local}
+	if cfg.ResetDay != 0 {
+		if cfg.ResetDay < 1 || cfg.ResetDay > 31 {
+			log.Fatalf("usage reset_day %d out of range", cfg.ResetDay)
+		}
+		u.resetDay = cfg.ResetDay
+	}
+	if len(cfg.Timezone) > 0 {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			log.Fatalf("error loading usage timezone '%s': %v", cfg.Timezone, err)
+		}
+		u.loc = loc
+	}
+	if len(cfg.Quota) > 0 {
+		quota, err := parseSize(cfg.Quota)
+		if err != nil {
+			log.Fatalf("error parsing usage quota '%s': %v", cfg.Quota, err)
+		}
+		u.quota = quota
+	}
+	if cfg.CapPercent > 0 && u.quota == 0 {
+		log.Fatal("usage cap_percent requires a quota")
+	}
+	return &u
+}
+
+// dayStart returns the start of the day containing t.
+func (u *usage) dayStart(t time.Time) time.Time {
+	y, m, d := t.In(u.loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, u.loc)
+}
+
+// monthStart returns the start of the billing month containing t.
+func (u *usage) monthStart(t time.Time) time.Time {
+	t = t.In(u.loc)
+	y, m, _ := t.Date()
+	start := u.resetDate(y, m)
+	if t.Before(start) {
+		start = u.resetDate(y, m-1)
+	}
+	return start
+}
+
+// resetDate returns the start of the billing month that starts in the
+// given calendar month.
+func (u *usage) resetDate(y int, m time.Month) time.Time {
+	last := time.Date(y, m+1, 0, 0, 0, 0, 0, u.loc).Day()
+	return time.Date(y, m, min(u.resetDay, last), 0, 0, 0, 0, u.loc)
+}
+
+// remaining returns the data remaining in the quota.
+func (u *usage) remaining(used uint64) uint64 {
+	if used >= u.quota {
+		return 0
+	}
+	return u.quota - used
+}
+
+// parseSize parses a data size, such as 100GB or 1.5TiB, into bytes.
+func parseSize(s string) (uint64, error) {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"KiB", 1 << 10},
+		{"MiB", 1 << 20},
+		{"GiB", 1 << 30},
+		{"TiB", 1 << 40},
+		{"kB", 1e3},
+		{"KB", 1e3},
+		{"MB", 1e6},
+		{"GB", 1e9},
+		{"TB", 1e12},
+		{"B", 1},
+	}
+	s = strings.TrimSpace(s)
+	scale := float64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			scale = u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("invalid size '%s'", s)
+	}
+	return uint64(v * scale), nil
+}
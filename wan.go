@@ -5,9 +5,11 @@
 package main
 
 import (
-	"context"
+	"fmt"
 	"log"
-	"net"
+	"log/slog"
+	"strconv"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -26,29 +28,150 @@ func onlineString(online bool) string {
 
 type wan struct {
 	online     bool
-	ip         string
 	linkPoller *PolledSensor
 	ipPoller   *PolledSensor
+	// the public addresses, one per enabled IP family
+	ips []*publicIP
+	// the latency measurement, if enabled
+	latency       *latency
+	latencyPoller *PolledSensor
+	// the uplinks probed independently, if any
+	uplinks []*uplink
+	// refreshes the default route, independent of the probes
+	routePoller *PolledSensor
+	routeMsg    string
+	// removes the listener for default route change notifications
+	unlistenRoutes func()
+	// the outage history, if enabled
+	outages    *outages
+	outagesMsg string
+	log        *slog.Logger
 	ps         PubSub
+	stopic     string
+	probes     *probeSet
+	// removes the listener for link change notifications
+	unlisten func()
+	// delays the link refresh until a burst of link changes settles
+	linkHoldoff *time.Timer
+	mu          sync.Mutex
+	// the interface holding the default route when last checked
+	routeIf string
 }
 
+// the time link changes must settle before the link is refreshed
+const linkEventHoldoff = 2 * time.Second
+
 type wanConfig struct {
 	Entities []string
-	Link     pollerConfig
-	IP       pollerConfig
+	Link     wanLinkConfig
+	IP       wanIPConfig
+	Latency  latencyConfig
+	// The uplinks of a multi-WAN router, keyed by entity name.
+	Uplinks map[string]wanUplinkConfig
+}
+
+type wanLinkConfig struct {
+	pollerConfig `yaml:",inline"`
+	// The probes used to determine if the link is up.
+	Probes []probeConfig
+	// How many probes must succeed for the link to be up - any, all or quorum.
+	Policy string
+}
+
+type wanIPConfig struct {
+	pollerConfig `yaml:",inline"`
+	// The methods used to find the public IPv4 address, in order of preference.
+	IPv4 []ipMethodConfig `yaml:"ipv4"`
+	// The methods used to find the public IPv6 address, in order of preference.
+	IPv6 []ipMethodConfig `yaml:"ipv6"`
+}
+
+// publicIP is the public address of one IP family.
+type publicIP struct {
+	entity string
+	finder *ipFinder
+	// the last address found, retained while the address is unknown so
+	// changes across outages are detected.
+	ip string
+	// the address, or unknown
+	msg string
+	// the attributes of the address, being the source that found it
+	attrs string
 }
 
 func (w *wan) Publish() {
 	if w.linkPoller != nil {
 		w.ps.Publish("", onlineString(w.online))
 	}
-	if w.ipPoller != nil {
-		w.ps.Publish("/ip", w.ip)
+	for _, p := range w.ips {
+		w.ps.Publish("/"+p.entity, p.msg)
+		w.ps.Publish("/"+p.entity+"/attributes", p.attrs)
+	}
+	for _, u := range w.uplinks {
+		if len(u.msg) > 0 {
+			w.ps.Publish("/uplink/"+u.name, u.msg)
+		}
+	}
+	if w.routePoller != nil && len(w.routeMsg) > 0 {
+		w.ps.Publish("/default_route", w.routeMsg)
 	}
+	if w.outages != nil && len(w.outagesMsg) > 0 {
+		w.ps.Publish("/outages", w.outagesMsg)
+	}
+	if w.latencyPoller != nil {
+		for _, t := range w.latency.targets {
+			if len(t.msg) > 0 {
+				w.ps.Publish("/latency/"+t.name, t.msg)
+			}
+		}
+	}
+}
+
+// linkChanged triggers a link refresh, once the changes settle, if the
+// interface may carry WAN traffic, i.e. it is an uplink or holds the
+// default route.
+func (w *wan) linkChanged(ifname string) {
+	if len(ifname) > 0 {
+		// the route may already be gone, so also check where it was
+		iface, ok := defaultRouteIf()
+		w.mu.Lock()
+		relevant := ifname == iface || ifname == w.routeIf
+		if ok {
+			w.routeIf = iface
+		}
+		w.mu.Unlock()
+		for _, u := range w.uplinks {
+			if ifname == u.iface {
+				relevant = true
+			}
+		}
+		if !relevant {
+			return
+		}
+	}
+	w.linkHoldoff.Reset(linkEventHoldoff)
 }
 
 func (w *wan) RefreshLink(forced bool) {
-	online := getLink()
+	if iface, ok := defaultRouteIf(); ok {
+		w.mu.Lock()
+		w.routeIf = iface
+		w.mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		checkUplinks(w.uplinks)
+	}()
+	online := w.probes.check()
+	wg.Wait()
+	if len(w.uplinks) > 0 {
+		w.refreshUplinks(forced)
+	}
+	if w.outages != nil {
+		w.refreshOutages(online, forced)
+	}
 	if w.online != online || forced {
 		w.online = online
 		w.ps.Publish("", onlineString(w.online))
@@ -59,29 +182,123 @@ func (w *wan) RefreshLink(forced bool) {
 }
 
 func (w *wan) RefreshIP(forced bool) {
-	ip := getIP()
-	if w.ip != ip || forced {
-		w.ip = ip
-		w.ps.Publish("/ip", w.ip)
+	for _, p := range w.ips {
+		ip, source := p.finder.find()
+		msg := "unknown"
+		attrs := "{}"
+		if len(ip) > 0 {
+			msg = ip
+			attrs = fmt.Sprintf(`{"source": %s}`, strconv.Quote(source))
+			if len(p.ip) > 0 && ip != p.ip {
+				w.ps.Publish("/ip_changed", fmt.Sprintf(
+					`{"event_type": "ip_changed", "family": "%s", "old": "%s", "new": "%s", "source": %s}`,
+					p.finder.family, p.ip, ip, strconv.Quote(source)))
+			}
+			p.ip = ip
+		}
+		if p.attrs != attrs || forced {
+			p.attrs = attrs
+			w.ps.Publish("/"+p.entity+"/attributes", attrs)
+		}
+		if p.msg != msg || forced {
+			p.msg = msg
+			w.ps.Publish("/"+p.entity, msg)
+		}
 	}
 }
 
+func (w *wan) refreshUplinks(forced bool) {
+	for _, u := range w.uplinks {
+		msg := onlineString(u.online)
+		if u.msg != msg || forced {
+			u.msg = msg
+			w.ps.Publish("/uplink/"+u.name, msg)
+		}
+	}
+}
+
+// RefreshRoute refreshes the uplink holding the default route.
+func (w *wan) RefreshRoute(forced bool) {
+	msg := defaultRouteMsg(w.uplinks)
+	if w.routeMsg != msg || forced {
+		w.routeMsg = msg
+		w.ps.Publish("/default_route", msg)
+	}
+}
+
+func (w *wan) refreshOutages(online, forced bool) {
+	now := time.Now()
+	w.outages.update(now, online)
+	msg := w.outages.msg(now)
+	if w.outagesMsg != msg || forced {
+		w.outagesMsg = msg
+		w.ps.Publish("/outages", msg)
+	}
+}
+
+// RefreshLatency measures the latency to each target concurrently.
+func (w *wan) RefreshLatency(forced bool) {
+	var wg sync.WaitGroup
+	for _, t := range w.latency.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := w.latency.measure(t)
+			if t.msg != msg || forced {
+				t.msg = msg
+				w.ps.Publish("/latency/"+t.name, msg)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func (w *wan) Close() {
+	if w.unlisten != nil {
+		w.unlisten()
+		w.linkHoldoff.Stop()
+	}
+	if w.unlistenRoutes != nil {
+		w.unlistenRoutes()
+	}
 	w.linkPoller.Close()
+	w.routePoller.Close()
 	w.ipPoller.Close()
+	w.latencyPoller.Close()
+	if w.outages != nil {
+		if err := w.outages.save(); err != nil {
+			w.log.Warn("unable to save outage history", "err", err)
+		}
+	}
 }
 
 func (w *wan) Sync(ps PubSub) {
 	w.ps = ps
 	w.linkPoller.Sync(ps)
+	w.routePoller.Sync(ps)
 	w.ipPoller.Sync(ps)
+	w.latencyPoller.Sync(ps)
 }
 
-func newWAN(yamlCfg *yaml.Node) SyncCloser {
+func newWAN(mod *Module, yamlCfg *yaml.Node) SyncCloser {
 	cfg := wanConfig{
 		Entities: []string{"link", "ip"},
-		Link:     pollerConfig{Period: "1m"},
-		IP:       pollerConfig{Period: "15m"},
+		Link: wanLinkConfig{
+			pollerConfig: pollerConfig{Period: "1m"},
+			Probes:       defaultProbes,
+			Policy:       "any",
+		},
+		IP: wanIPConfig{
+			pollerConfig: pollerConfig{Period: "15m"},
+			IPv4:         defaultIPMethods,
+			IPv6:         defaultIPMethods,
+		},
+		Latency: latencyConfig{
+			pollerConfig: pollerConfig{Period: "5m"},
+			Count:        10,
+			Interval:     "200ms",
+			Timeout:      "1s",
+		},
 	}
 	err := yamlCfg.Decode(&cfg)
 	if err != nil {
@@ -93,20 +310,67 @@ func newWAN(yamlCfg *yaml.Node) SyncCloser {
 		entities[e] = true
 	}
 	w := wan{
-		online: getLink(),
 		ps:     StubPubSub{},
+		stopic: mod.StateTopic(""),
+		log:    mod.log,
+		probes: newProbeSet(cfg.Link.Probes, cfg.Link.Policy, probeBind{}, mod.log),
+	}
+	w.online = w.probes.check()
+	if len(cfg.Uplinks) > 0 {
+		if !entities["link"] {
+			log.Fatal("wan uplinks requires the link entity")
+		}
+		w.uplinks = newUplinks(cfg.Uplinks, &cfg.Link, mod.log)
+	}
+	if entities["outages"] {
+		if !entities["link"] {
+			log.Fatal("wan outages requires the link entity")
+		}
+		w.outages = newOutages(mod.name+"-outages", mod.log)
 	}
 	if entities["link"] {
 		w.linkPoller = &PolledSensor{
 			topic:  "",
-			poller: NewPoller(&cfg.Link, w.RefreshLink),
+			poller: mod.NewPoller(&cfg.Link.pollerConfig, w.RefreshLink),
 			ps:     StubPubSub{},
 		}
+		w.routeIf, _ = defaultRouteIf()
+		w.linkHoldoff = time.AfterFunc(linkEventHoldoff, w.linkPoller.poller.RefreshAsync)
+		w.linkHoldoff.Stop()
+		w.unlisten = linkEvents.listen(w.linkChanged)
+	}
+	if len(w.uplinks) > 0 {
+		w.routePoller = &PolledSensor{
+			topic:  "/default_route",
+			poller: mod.NewPoller(&cfg.Link.pollerConfig, w.RefreshRoute),
+			ps:     StubPubSub{},
+		}
+		w.unlistenRoutes = linkEvents.listenRoutes(w.routePoller.poller.RefreshAsync)
 	}
 	if entities["ip"] {
+		w.ips = append(w.ips, &publicIP{
+			entity: "ip",
+			finder: newIPFinder("ipv4", cfg.IP.IPv4, mod.log),
+		})
+	}
+	if entities["ipv6"] {
+		w.ips = append(w.ips, &publicIP{
+			entity: "ipv6",
+			finder: newIPFinder("ipv6", cfg.IP.IPv6, mod.log),
+		})
+	}
+	if len(w.ips) > 0 {
 		w.ipPoller = &PolledSensor{
 			topic:  "/ip",
-			poller: NewPoller(&cfg.IP, w.RefreshIP),
+			poller: mod.NewPoller(&cfg.IP.pollerConfig, w.RefreshIP),
+			ps:     StubPubSub{},
+		}
+	}
+	if entities["latency"] {
+		w.latency = newLatency(&cfg.Latency)
+		w.latencyPoller = &PolledSensor{
+			topic:  "/latency",
+			poller: mod.NewPoller(&cfg.Latency.pollerConfig, w.RefreshLatency),
 			ps:     StubPubSub{},
 		}
 	}
@@ -118,7 +382,7 @@ func (w *wan) Config() []EntityConfig {
 	if w.linkPoller != nil {
 		cfg := map[string]any{
 			"name":         "WAN",
-			"state_topic":  "~/wan",
+			"state_topic":  w.stopic,
 			"device_class": "connectivity",
 			"icon":         "mdi:wan",
 			"payload_on":   "online",
@@ -126,73 +390,142 @@ func (w *wan) Config() []EntityConfig {
 		}
 		config = append(config, EntityConfig{"link", "binary_sensor", cfg})
 	}
-	if w.ipPoller != nil {
+	for _, u := range w.uplinks {
 		cfg := map[string]any{
-			"name":        "WAN IP",
-			"state_topic": "~/wan/ip",
-			"icon":        "mdi:ip",
+			"name":         "WAN " + u.name,
+			"state_topic":  w.stopic + "/uplink/" + u.name,
+			"device_class": "connectivity",
+			"icon":         "mdi:wan",
+			"payload_on":   "online",
+			"payload_off":  "offline",
 		}
-		config = append(config, EntityConfig{"ip", "sensor", cfg})
+		config = append(config, EntityConfig{"uplink-" + u.name, "binary_sensor", cfg})
 	}
-	return config
-}
-
-type dialer func(ctx context.Context, network, address string) (net.Conn, error)
-
-func lookupGoogle(d dialer) (addrs []string, err error) {
-	r := net.Resolver{
-		PreferGo: true,
-		Dial:     d,
+	if len(w.uplinks) > 0 {
+		topic := w.stopic + "/default_route"
+		cfg := map[string]any{
+			"name":                     "WAN default route",
+			"state_topic":              topic,
+			"value_template":           "{{value_json.uplink}}",
+			"icon":                     "mdi:router-network",
+			"json_attributes_topic":    topic,
+			"json_attributes_template": "{{ {'interface': value_json.interface} | tojson }}",
+		}
+		setFieldAvailability(cfg, topic, "uplink")
+		config = append(config, EntityConfig{"default_route", "sensor", cfg})
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	return r.LookupHost(ctx, "www.google.com")
-}
-
-func getLink() bool {
-	dialers := []dialer{CloudFlareDNSDialer, GoogleDNSDialer, OpenDNSDialer}
-	for _, dialer := range dialers {
-		_, err := lookupGoogle(dialer)
-		if err == nil {
-			return true
+	if w.outages != nil {
+		topic := w.stopic + "/outages"
+		for _, f := range outageFields {
+			cfg := map[string]any{
+				"name":           outageNames[f],
+				"state_topic":    topic,
+				"value_template": fmt.Sprintf("{{value_json.%s}}", f),
+			}
+			switch f {
+			case "count":
+				cfg["state_class"] = "total_increasing"
+				cfg["icon"] = "mdi:counter"
+			case "last_start", "up_since":
+				cfg["device_class"] = "timestamp"
+			case "last_duration":
+				cfg["device_class"] = "duration"
+				cfg["unit_of_measurement"] = "s"
+			default:
+				cfg["unit_of_measurement"] = "%"
+				cfg["state_class"] = "measurement"
+				cfg["icon"] = "mdi:percent"
+			}
+			setFieldAvailability(cfg, topic, f)
+			config = append(config, EntityConfig{"outages-" + f, "sensor", cfg})
 		}
 	}
-	return false
-}
-
-func getIP() string {
-	r := net.Resolver{
-		PreferGo: true,
-		Dial:     OpenDNSDialer,
+	for _, p := range w.ips {
+		topic := w.stopic + "/" + p.entity
+		cfg := map[string]any{
+			"name":                  ipNames[p.entity],
+			"state_topic":           topic,
+			"icon":                  "mdi:ip",
+			"json_attributes_topic": topic + "/attributes",
+			"availability": []map[string]string{
+				{"topic": "~"},
+				{"topic": topic,
+					"value_template": "{{'offline' if value == 'unknown' else 'online'}}",
+				},
+			},
+			"availability_mode": "all",
+		}
+		config = append(config, EntityConfig{p.entity, "sensor", cfg})
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	addr, err := r.LookupHost(ctx, "myip.opendns.com")
-	if err != nil {
-		return "unknown"
+	if w.ipPoller != nil {
+		cfg := map[string]any{
+			"name":        "WAN IP changed",
+			"state_topic": w.stopic + "/ip_changed",
+			"event_types": []string{"ip_changed"},
+			"icon":        "mdi:ip-network",
+		}
+		config = append(config, EntityConfig{"ip_changed", "event", cfg})
 	}
-	return addr[0]
+	if w.latencyPoller != nil {
+		for _, t := range w.latency.targets {
+			topic := w.stopic + "/latency/" + t.name
+			for _, f := range latencyFields {
+				cfg := map[string]any{
+					"name":                fmt.Sprintf("WAN %s %s", t.name, latencyNames[f]),
+					"state_topic":         topic,
+					"value_template":      fmt.Sprintf("{{value_json.%s}}", f),
+					"unit_of_measurement": "ms",
+					"device_class":        "duration",
+					"state_class":         "measurement",
+					"icon":                "mdi:timer-outline",
+				}
+				if f == "loss" {
+					cfg["unit_of_measurement"] = "%"
+					cfg["icon"] = "mdi:package-variant-remove"
+					delete(cfg, "device_class")
+				}
+				setFieldAvailability(cfg, topic, f)
+				config = append(config, EntityConfig{"latency-" + t.name + "-" + f, "sensor", cfg})
+			}
+		}
+	}
+	return config
 }
 
-// CloudFlareDNSDialer connects to a CloudFlare DNS server
-func CloudFlareDNSDialer(ctx context.Context, _, _ string) (net.Conn, error) {
-	d := net.Dialer{}
-	return d.DialContext(ctx, "udp", "1.1.1.1:53")
+// the outage entity fields, in message order
+var outageFields = []string{
+	"count",
+	"last_start",
+	"last_duration",
+	"up_since",
+	"availability_24h",
+	"availability_7d",
 }
 
-// GoogleDNSDialer connects to a Google DNS server
-func GoogleDNSDialer(ctx context.Context, _, _ string) (net.Conn, error) {
-	d := net.Dialer{}
-	return d.DialContext(ctx, "udp", "8.8.8.8:53")
+// mapping from outage field to HA display name
+var outageNames = map[string]string{
+	"count":            "WAN outages",
+	"last_start":       "WAN last outage start",
+	"last_duration":    "WAN last outage duration",
+	"up_since":         "WAN up since",
+	"availability_24h": "WAN availability 24h",
+	"availability_7d":  "WAN availability 7d",
 }
 
-// OpenDNSDialer connects to an OpenDNS DNS server
-// Note that this assumes the default DNS lookup is functional.
-func OpenDNSDialer(ctx context.Context, _, _ string) (net.Conn, error) {
-	addrs, err := net.LookupHost("resolver1.opendns.com")
-	if err != nil {
-		return nil, err
-	}
-	d := net.Dialer{}
-	return d.DialContext(ctx, "udp", addrs[0]+":53")
+// mapping from public IP entity to HA display name
+var ipNames = map[string]string{
+	"ip":   "WAN IP",
+	"ipv6": "WAN IPv6",
+}
+
+// the latency entity fields, in message order
+var latencyFields = []string{"rtt_min", "rtt_avg", "rtt_max", "jitter", "loss"}
+
+// mapping from latency field to HA display name suffix
+var latencyNames = map[string]string{
+	"rtt_min": "RTT min",
+	"rtt_avg": "RTT avg",
+	"rtt_max": "RTT max",
+	"jitter":  "jitter",
+	"loss":    "loss",
 }
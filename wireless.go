@@ -0,0 +1,352 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// nl80211 commands and attributes, from linux/nl80211.h
+const (
+	nl80211CmdGetInterface = 5
+	nl80211CmdGetStation   = 17
+	nl80211CmdGetSurvey    = 50
+
+	nl80211AttrIfindex    = 3
+	nl80211AttrIftype     = 5
+	nl80211AttrMac        = 6
+	nl80211AttrStaInfo    = 21
+	nl80211AttrWiphyFreq  = 38
+	nl80211AttrSSID       = 52
+	nl80211AttrSurveyInfo = 84
+
+	nl80211IftypeStation = 2
+	nl80211IftypeAP      = 3
+
+	nl80211StaInfoInactiveTime = 1
+	nl80211StaInfoRxBytes      = 2
+	nl80211StaInfoTxBytes      = 3
+	nl80211StaInfoSignal       = 7
+	nl80211StaInfoTxBitrate    = 8
+	nl80211StaInfoRxBitrate    = 14
+	nl80211StaInfoConnTime     = 16
+	nl80211StaInfoRxBytes64    = 23
+	nl80211StaInfoTxBytes64    = 24
+
+	nl80211RateInfoBitrate   = 1
+	nl80211RateInfoBitrate32 = 5
+
+	nl80211SurveyInfoFrequency = 1
+	nl80211SurveyInfoNoise     = 2
+	nl80211SurveyInfoInUse     = 3
+)
+
+// the wireless entities, in message order
+var wirelessEntities = []string{
+	"ssid",
+	"bssid",
+	"frequency",
+	"channel",
+	"signal",
+	"link_quality",
+	"noise",
+	"tx_bitrate",
+	"rx_bitrate",
+}
+
+// isWireless returns true if the interface is a wireless interface.
+func isWireless(name string) bool {
+	_, err := os.Stat(hostPath("/sys/class/net/" + name + "/wireless"))
+	return err == nil
+}
+
+// nl80211 is a connection to the nl80211 generic netlink family.
+type nl80211 struct {
+	*nlConn
+	family uint16
+}
+
+func dialNl80211() (*nl80211, error) {
+	c, err := dialNetlink(syscall.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	family, err := c.genlFamily("nl80211")
+	if err != nil {
+		c.Close()
+		return nil, errors.Wrap(err, "nl80211 unavailable")
+	}
+	return &nl80211{c, family}, nil
+}
+
+// nl80211Conn holds an nl80211 connection across polls, only redialling if
+// the connection fails.
+type nl80211Conn struct {
+	mu sync.Mutex
+	c  *nl80211
+}
+
+// do calls f with the connection, dialling it if necessary.
+func (h *nl80211Conn) do(f func(c *nl80211)) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.c != nil && h.c.failed {
+		h.c.Close()
+		h.c = nil
+	}
+	if h.c == nil {
+		c, err := dialNl80211()
+		if err != nil {
+			return err
+		}
+		h.c = c
+	}
+	f(h.c)
+	return nil
+}
+
+// Close closes the connection, waiting for any call in progress.
+func (h *nl80211Conn) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.c != nil {
+		h.c.Close()
+		h.c = nil
+	}
+}
+
+// wifiInterface is the nl80211 state of a wireless interface.
+type wifiInterface struct {
+	iftype uint32
+	ssid   string
+	// MHz, or 0 if not known
+	freq uint32
+}
+
+func (c *nl80211) getInterface(ifindex int) (wifiInterface, error) {
+	wi := wifiInterface{}
+	resp, err := c.genlRequest(c.family, nl80211CmdGetInterface, 0,
+		nlAttrU32(nl80211AttrIfindex, uint32(ifindex)))
+	if err != nil {
+		return wi, err
+	}
+	for _, r := range resp {
+		attrs := parseNlAttrs(r)
+		if v, ok := attrs[nl80211AttrIftype]; ok && len(v) >= 4 {
+			wi.iftype = binary.NativeEndian.Uint32(v)
+		}
+		if v, ok := attrs[nl80211AttrSSID]; ok {
+			wi.ssid = string(v)
+		}
+		if v, ok := attrs[nl80211AttrWiphyFreq]; ok && len(v) >= 4 {
+			wi.freq = binary.NativeEndian.Uint32(v)
+		}
+	}
+	return wi, nil
+}
+
+// station is the nl80211 state of a station associated with an interface -
+// the AP for a station interface, or a client for an AP interface.
+type station struct {
+	mac       string
+	signal    int
+	hasSignal bool
+	// Mbit/s, or 0 if not known
+	txBitrate float64
+	rxBitrate float64
+	rxBytes   uint64
+	txBytes   uint64
+	// seconds
+	connected uint32
+	// milliseconds
+	inactive uint32
+}
+
+func (c *nl80211) getStations(ifindex int) ([]station, error) {
+	resp, err := c.genlRequest(c.family, nl80211CmdGetStation, syscall.NLM_F_DUMP,
+		nlAttrU32(nl80211AttrIfindex, uint32(ifindex)))
+	if err != nil {
+		return nil, err
+	}
+	ss := []station{}
+	for _, r := range resp {
+		attrs := parseNlAttrs(r)
+		s := station{}
+		if v, ok := attrs[nl80211AttrMac]; ok {
+			s.mac = net.HardwareAddr(v).String()
+		}
+		info := parseNlAttrs(attrs[nl80211AttrStaInfo])
+		if v, ok := info[nl80211StaInfoSignal]; ok && len(v) >= 1 {
+			s.signal = int(int8(v[0]))
+			s.hasSignal = true
+		}
+		s.txBitrate = bitrate(info[nl80211StaInfoTxBitrate])
+		s.rxBitrate = bitrate(info[nl80211StaInfoRxBitrate])
+		s.rxBytes = staBytes(info, nl80211StaInfoRxBytes64, nl80211StaInfoRxBytes)
+		s.txBytes = staBytes(info, nl80211StaInfoTxBytes64, nl80211StaInfoTxBytes)
+		if v, ok := info[nl80211StaInfoConnTime]; ok && len(v) >= 4 {
+			s.connected = binary.NativeEndian.Uint32(v)
+		}
+		if v, ok := info[nl80211StaInfoInactiveTime]; ok && len(v) >= 4 {
+			s.inactive = binary.NativeEndian.Uint32(v)
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+// staBytes returns a byte count from the station info, preferring the
+// 64-bit attribute if present.
+func staBytes(info map[uint16][]byte, attr64, attr32 uint16) uint64 {
+	if v, ok := info[attr64]; ok && len(v) >= 8 {
+		return binary.NativeEndian.Uint64(v)
+	}
+	if v, ok := info[attr32]; ok && len(v) >= 4 {
+		return uint64(binary.NativeEndian.Uint32(v))
+	}
+	return 0
+}
+
+// bitrate returns the bitrate, in Mbit/s, from a nested rate info attribute.
+func bitrate(b []byte) float64 {
+	info := parseNlAttrs(b)
+	if v, ok := info[nl80211RateInfoBitrate32]; ok && len(v) >= 4 {
+		return float64(binary.NativeEndian.Uint32(v)) / 10
+	}
+	if v, ok := info[nl80211RateInfoBitrate]; ok && len(v) >= 2 {
+		return float64(binary.NativeEndian.Uint16(v)) / 10
+	}
+	return 0
+}
+
+// getNoise returns the noise floor, in dBm, of the channel in use.
+func (c *nl80211) getNoise(ifindex int) (int, bool) {
+	resp, err := c.genlRequest(c.family, nl80211CmdGetSurvey, syscall.NLM_F_DUMP,
+		nlAttrU32(nl80211AttrIfindex, uint32(ifindex)))
+	if err != nil {
+		return 0, false
+	}
+	for _, r := range resp {
+		info := parseNlAttrs(parseNlAttrs(r)[nl80211AttrSurveyInfo])
+		if _, ok := info[nl80211SurveyInfoInUse]; !ok {
+			continue
+		}
+		if v, ok := info[nl80211SurveyInfoNoise]; ok && len(v) >= 1 {
+			return int(int8(v[0])), true
+		}
+	}
+	return 0, false
+}
+
+// freqChannel returns the channel for a frequency in MHz.
+func freqChannel(freq uint32) (int, bool) {
+	switch {
+	case freq == 2484:
+		return 14, true
+	case freq == 5935:
+		// 6GHz channel 2 is out of sequence
+		return 2, true
+	case freq >= 2412 && freq < 2484:
+		return int(freq-2407) / 5, true
+	case freq >= 5955 && freq <= 7115:
+		return int(freq-5950) / 5, true
+	case freq >= 5000 && freq < 5950:
+		return int(freq-5000) / 5, true
+	}
+	return 0, false
+}
+
+// signalQuality maps a signal level, in dBm, to a link quality percentage.
+func signalQuality(signal int) int {
+	return min(max(2*(signal+100), 0), 100)
+}
+
+// readWireless reads the wireless state of a station interface, returning
+// the entity values as JSON values.
+//
+// Values are read via nl80211, falling back to /proc/net/wireless, which
+// only provides the signal, link quality and noise.
+func readWireless(nc *nl80211Conn, name string) map[string]string {
+	w := map[string]string{}
+	nc.do(func(c *nl80211) {
+		if iface, err := hostInterface(name); err == nil {
+			if wi, err := c.getInterface(iface.Index); err == nil && wi.iftype == nl80211IftypeStation {
+				if len(wi.ssid) > 0 {
+					w["ssid"] = strconv.Quote(wi.ssid)
+				}
+				if wi.freq != 0 {
+					w["frequency"] = strconv.Itoa(int(wi.freq))
+					if ch, ok := freqChannel(wi.freq); ok {
+						w["channel"] = strconv.Itoa(ch)
+					}
+				}
+				if ss, err := c.getStations(iface.Index); err == nil && len(ss) > 0 {
+					s := ss[0]
+					w["bssid"] = strconv.Quote(s.mac)
+					if s.hasSignal {
+						w["signal"] = strconv.Itoa(s.signal)
+						w["link_quality"] = strconv.Itoa(signalQuality(s.signal))
+					}
+					if s.txBitrate > 0 {
+						w["tx_bitrate"] = fmt.Sprintf("%.1f", s.txBitrate)
+					}
+					if s.rxBitrate > 0 {
+						w["rx_bitrate"] = fmt.Sprintf("%.1f", s.rxBitrate)
+					}
+				}
+				if noise, ok := c.getNoise(iface.Index); ok {
+					w["noise"] = strconv.Itoa(noise)
+				}
+			}
+		}
+	})
+	if _, ok := w["signal"]; !ok {
+		readProcWireless(name, w)
+	}
+	return w
+}
+
+// readProcWireless reads the link quality, signal and noise for the
+// interface from /proc/net/wireless.
+func readProcWireless(name string, w map[string]string) {
+	f, err := os.Open(hostPath("/proc/net/wireless"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		ifname, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(ifname) != name {
+			continue
+		}
+		// status, link quality, level, noise
+		fields := strings.Fields(rest)
+		if len(fields) < 4 {
+			return
+		}
+		if q, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "."), 64); err == nil {
+			// quality is out of 70 for most drivers
+			w["link_quality"] = strconv.Itoa(min(int(q*100/70), 100))
+		}
+		if l, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64); err == nil && l < 0 {
+			w["signal"] = strconv.Itoa(int(l))
+		}
+		if n, err := strconv.ParseFloat(strings.TrimSuffix(fields[3], "."), 64); err == nil && n < 0 && n > -256 {
+			w["noise"] = strconv.Itoa(int(n))
+		}
+		return
+	}
+}
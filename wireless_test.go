@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2026 Kent Gibson <warthog618@gmail.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import "testing"
+
+func TestBitrate(t *testing.T) {
+	requireLittleEndian(t)
+	patterns := []struct {
+		name string
+		b    []byte
+		rate float64
+	}{
+		{"empty", nil, 0},
+		// bitrate32 and the 80MHz width flag
+		{"bitrate32", []byte{
+			0x08, 0x00, 0x05, 0x00, 0x02, 0x28, 0x00, 0x00,
+			0x04, 0x00, 0x08, 0x00,
+		}, 1024.2},
+		// bitrate32 is preferred as the u16 bitrate overflows
+		{"both", []byte{
+			0x08, 0x00, 0x05, 0x00, 0xfc, 0x4d, 0x01, 0x00,
+			0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+		}, 8550},
+		// legacy 54Mbit/s
+		{"legacy", []byte{0x06, 0x00, 0x01, 0x00, 0x1c, 0x02, 0x00, 0x00}, 54},
+		{"short bitrate", []byte{0x05, 0x00, 0x01, 0x00, 0x1c, 0x00, 0x00, 0x00}, 0},
+		{"truncated", []byte{0x08, 0x00, 0x05, 0x00, 0x02, 0x28}, 0},
+	}
+	for _, p := range patterns {
+		t.Run(p.name, func(t *testing.T) {
+			if rate := bitrate(p.b); rate != p.rate {
+				t.Errorf("got %v, want %v", rate, p.rate)
+			}
+		})
+	}
+}
+
+func TestFreqChannel(t *testing.T) {
+	patterns := []struct {
+		freq uint32
+		ch   int
+		ok   bool
+	}{
+		{2412, 1, true},
+		{2437, 6, true},
+		{2472, 13, true},
+		{2484, 14, true},
+		{5180, 36, true},
+		{5500, 100, true},
+		{5825, 165, true},
+		{5935, 2, true},
+		{5955, 1, true},
+		{6115, 33, true},
+		{7115, 233, true},
+		{0, 0, false},
+		{2400, 0, false},
+		{58320, 0, false},
+	}
+	for _, p := range patterns {
+		ch, ok := freqChannel(p.freq)
+		if ch != p.ch || ok != p.ok {
+			t.Errorf("%d: got (%d, %v), want (%d, %v)", p.freq, ch, ok, p.ch, p.ok)
+		}
+	}
+}